@@ -4,15 +4,28 @@ import (
 	"agent/core"
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
-type User struct{}
+type User struct {
+	// plain prints the bare reply without the colored prefix, for piping
+	plain bool
+	out   io.Writer
+}
 
 func (ui User) WriteMessage(msg string) error {
-	fmt.Printf("\u001b[93mOllama\u001b[0m: %s\n", msg)
-	return nil
+	if ui.plain {
+		_, err := fmt.Fprintln(ui.out, msg)
+		return err
+	}
+	_, err := fmt.Fprintf(ui.out, "\u001b[93mOllama\u001b[0m: %s\n", msg)
+	return err
 }
 
 func (ui User) ReadMessage() (string, bool) {
@@ -23,12 +36,83 @@ func (ui User) ReadMessage() (string, bool) {
 	return scanner.Text(), true
 }
 
+// Approve asks on the terminal whether a command may run or a file may be
+// changed. Anything but y or yes, including EOF, denies it.
+func (ui User) Approve(toolName string, args map[string]any) bool {
+	question := fmt.Sprintf("Allow %s?", describeCall(toolName, args))
+	if command, ok := args["command"].(string); ok && command != "" {
+		question = fmt.Sprintf("Run `%s`?", command)
+	}
+	return ui.confirm(question)
+}
+
+// ApproveBatch lists the commands and file changes of one model turn and
+// asks once whether all of them may go ahead.
+func (ui User) ApproveBatch(calls []core.ToolCall) bool {
+	fmt.Fprintf(ui.out, "The model wants to make %d changes:\n", len(calls))
+	for _, tc := range calls {
+		fmt.Fprintf(ui.out, "  %s\n", describeCall(tc.Function.Name, tc.Function.Arguments))
+	}
+	return ui.confirm(fmt.Sprintf("Allow all %d?", len(calls)))
+}
+
+// confirm asks question and reports whether the answer was y or yes.
+func (ui User) confirm(question string) bool {
+	fmt.Fprintf(ui.out, "%s [y/N] ", question)
+	answer, ok := ui.ReadMessage()
+	if !ok {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// describeCall sums up a tool call for an approval prompt: the command it
+// runs, or the file it touches and how much content it writes.
+func describeCall(toolName string, args map[string]any) string {
+	if command, ok := args["command"].(string); ok && command != "" {
+		return fmt.Sprintf("%s `%s`", toolName, command)
+	}
+	if path, ok := args["path"].(string); ok && path != "" {
+		if content, ok := args["content"].(string); ok {
+			return fmt.Sprintf("%s on %s (%d bytes)", toolName, path, len(content))
+		}
+		return fmt.Sprintf("%s on %s", toolName, path)
+	}
+	return fmt.Sprintf("%s with args %v", toolName, args)
+}
+
 func main() {
+	plain := flag.Bool("plain", os.Getenv("KUTAGENT_PLAIN") != "", "print replies without the colored prefix (also KUTAGENT_PLAIN)")
+	configTools := flag.Bool("config-tools", false, "let the model read and change its own settings via get_config/set_config")
+	root := flag.String("root", os.Getenv("KUTAGENT_PROJECT_ROOT"), "project directory the tools work in (also KUTAGENT_PROJECT_ROOT; default the current directory)")
+	approve := flag.Bool("approve", os.Getenv("KUTAGENT_APPROVE") != "", "ask before running commands or changing files (also KUTAGENT_APPROVE)")
+	session := flag.String("session", os.Getenv("KUTAGENT_SESSION"), "save the conversation to this file after every turn and resume it on start (also KUTAGENT_SESSION)")
+	skipModelCheck := flag.Bool("skip-model-check", os.Getenv("KUTAGENT_SKIP_MODEL_CHECK") != "", "don't check at startup that the model has been pulled, e.g. behind a proxy without /api/tags (also KUTAGENT_SKIP_MODEL_CHECK)")
+	timeout := flag.Duration("timeout", 0, "end the whole session after this long, e.g. 30m (0 runs until exit)")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
 	client := core.NewClient()
-	userInput := User{}
+	userInput := User{plain: *plain, out: os.Stdout}
 	agent := core.NewAgent(client, userInput)
-	err := agent.Run(context.TODO())
+	agent.ConfigTools = *configTools
+	agent.ProjectRoot = *root
+	agent.SessionPath = *session
+	agent.SkipModelCheck = *skipModelCheck
+	if *approve {
+		agent.Approve = userInput.Approve
+		agent.ApproveBatch = userInput.ApproveBatch
+	}
+	err := agent.Run(ctx)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(agent.ErrOut, err)
 	}
 }
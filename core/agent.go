@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,20 +23,370 @@ type User interface {
 	WriteMessage(string) error
 }
 
+// ErrTooManyToolErrors is returned when the model keeps producing tool calls
+// that fail, MaxErrors rounds in a row.
+var ErrTooManyToolErrors = errors.New("too many consecutive failed tool calls")
+
+// modelLoadTimeout bounds the single retry made when the first attempt
+// looks like Ollama was still loading the model, which can take minutes for
+// large models on slow disks.
+const modelLoadTimeout = 5 * time.Minute
+
+// defaultMaxSteps is used unless KUTAGENT_MAX_STEPS or Agent.MaxSteps is set.
+const defaultMaxSteps = 5
+
+func maxStepsFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("KUTAGENT_MAX_STEPS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxSteps
+}
+
+// maxSteps returns MaxSteps, or the default when it isn't set, lowered to
+// the budget the model chose through set_config.
+func (agent *Agent) maxSteps() int {
+	n := agent.MaxSteps
+	if n <= 0 {
+		n = defaultMaxSteps
+	}
+	if agent.modelMaxSteps > 0 && agent.modelMaxSteps < n {
+		return agent.modelMaxSteps
+	}
+	return n
+}
+
+// defaultMaxErrors is used unless Agent.MaxErrors is set.
+const defaultMaxErrors = 3
+
+// maxErrors returns MaxErrors, or the default when it isn't set.
+func (agent *Agent) maxErrors() int {
+	if agent.MaxErrors <= 0 {
+		return defaultMaxErrors
+	}
+	return agent.MaxErrors
+}
+
+// maxFallbacks bounds how many fallback models a single turn may try.
+const maxFallbacks = 3
+
+// ErrMaxSteps is returned when a turn runs out of tool-calling steps and no
+// StepLimitPrompt is configured.
+var ErrMaxSteps = errors.New("max tool-calling steps exceeded")
+
+// DefaultStepLimitPrompt asks the model for its best answer once the step
+// budget is spent.
+const DefaultStepLimitPrompt = "You have reached the limit of tool calls for this request. " +
+	"Do not call any more tools. Summarize what you have done so far and give your current best answer."
+
 type Agent struct {
-	client *OllamaClient
-	user   User
+	client  *OllamaClient
+	user    User
+	session *Session
+
+	// MaxSteps bounds the number of successful tool-calling rounds per turn.
+	// Zero means defaultMaxSteps.
+	MaxSteps int
+	// MaxErrors bounds the number of consecutive rounds with failing tool calls.
+	// Zero means defaultMaxErrors.
+	MaxErrors int
+	// StepTimeout bounds each provider call on its own, so a slow early step
+	// can't starve the later ones.
+	StepTimeout time.Duration
+	// ToolTimeout bounds each round of tool calls, separately from the
+	// provider calls, so a long build isn't held to the time a model reply
+	// gets. Per-tool timeouts such as run_shell's timeout_sec apply within
+	// it. Zero means StepTimeout. It defaults to KUTAGENT_TOOL_TIMEOUT, or
+	// defaultToolTimeout.
+	ToolTimeout time.Duration
+	// StepCounter prefixes each tool trace with the step number out of
+	// MaxSteps, e.g. "[2/5]", to show how close a turn is to the limit.
+	StepCounter bool
+	// Prefill, when set, is sent as the start of the assistant's reply so the
+	// model continues from it, e.g. "{" to force a JSON answer.
+	Prefill string
+	// Options are passed to the provider as model options, e.g. temperature;
+	// options left out keep Ollama's defaults. They start from OLLAMA_<KEY>
+	// variables such as OLLAMA_TEMPERATURE, OLLAMA_TOP_P, OLLAMA_NUM_CTX and
+	// OLLAMA_SEED.
+	Options map[string]any
+	// DisabledTools are never offered to the model nor executed.
+	DisabledTools map[string]bool
+	// Approve, when set, is asked before each call to one of MutatingTools
+	// runs; if it returns false the call is skipped and the model is told the
+	// user denied it. Nil approves everything.
+	Approve func(toolName string, args map[string]any) bool
+	// ApproveBatch, when set, is asked once about all the calls to
+	// MutatingTools in a model turn that makes several, e.g. a scaffolding
+	// step writing many files. If it returns false none of them run. Turns
+	// with a single such call still go through Approve.
+	ApproveBatch func(calls []ToolCall) bool
+	// EnabledTools, when not empty, is an allowlist: only these tools are
+	// offered and executed. It defaults to KUTAGENT_ENABLED_TOOLS.
+	EnabledTools map[string]bool
+	// ConfigTools offers the model get_config and set_config so it can tune
+	// its own settings. Off by default.
+	ConfigTools bool
+	// ToolResultLimit, when positive, caps how many bytes of a tool result go
+	// into the conversation. The full result is kept aside and the model can
+	// page through it with read_tool_result.
+	ToolResultLimit int
+	// FallbackModels are tried in order, for the rest of the turn, when the
+	// current model fails with a model-level error such as not found or out
+	// of memory. At most maxFallbacks of them are tried per turn.
+	FallbackModels []string
+	// SkipModelCheck turns off the check Run makes before the first prompt
+	// that the model has been pulled, for servers or proxies that don't
+	// offer /api/tags. It defaults to KUTAGENT_SKIP_MODEL_CHECK.
+	SkipModelCheck bool
+	// StepLimitPrompt is sent, with tools withheld, when MaxSteps is reached
+	// so the model summarizes its progress. If empty, ErrMaxSteps is
+	// returned instead.
+	StepLimitPrompt string
+	// TurnTimeout optionally bounds a whole turn across all its steps and
+	// tool rounds. Zero means a turn is only limited by MaxSteps and the
+	// per-step timeouts.
+	TurnTimeout time.Duration
+	// ThinkingNotice is how long a provider request may run before a "still
+	// thinking" notice is printed, so a slow model doesn't look stuck. Zero
+	// disables it.
+	ThinkingNotice time.Duration
+	// SystemPrompt, when set, is sent as a system message ahead of the
+	// conversation on every request. It is not part of the history, so it
+	// is never duplicated and survives /reset. It defaults to
+	// OLLAMA_SYSTEM_PROMPT.
+	SystemPrompt string
+	// ShowUsage prints the tokens and model time each turn used, and the
+	// running total for the session, after the reply. It defaults to
+	// KUTAGENT_SHOW_USAGE.
+	ShowUsage bool
+	// MaxHistoryMessages and MaxHistoryTokens, when set, bound the history
+	// sent with each request by dropping the oldest messages; see
+	// trimHistory. Tokens are estimated. They default to
+	// KUTAGENT_MAX_HISTORY_MESSAGES and KUTAGENT_MAX_HISTORY_TOKENS.
+	MaxHistoryMessages int
+	MaxHistoryTokens   int
+	// SessionPath, when set, is where the conversation is saved after every
+	// turn; Run resumes it from there if the file exists. It defaults to
+	// KUTAGENT_SESSION.
+	SessionPath string
+	// ProjectRoot is the directory the file tools are confined to and shell
+	// commands run in. It defaults to KUTAGENT_PROJECT_ROOT, or else the
+	// working directory, and is resolved once when the agent starts.
+	ProjectRoot string
+	// CleanShellEnv starts run_shell commands from an empty environment
+	// instead of the agent's, so secrets in it can't leak into commands and
+	// their behavior doesn't depend on it; variables the model passes in env
+	// are still set. It defaults to KUTAGENT_SHELL_CLEAN_ENV.
+	CleanShellEnv bool
+	// SearchBackend answers web_search. When nil the backend is chosen by
+	// KUTAGENT_SEARCH_BACKEND.
+	SearchBackend SearchBackend
+	// Limits bounds how much the file, listing, shell and fetch tools read
+	// or return. Unset fields keep the 1MB default.
+	Limits Limits
+	// MetricsOut, when set, receives a JSON line per turn with request and
+	// response sizes, tool call and token counts, but no contents. It can
+	// also be set with KUTAGENT_METRICS naming a file to append to.
+	MetricsOut io.Writer
+	// ShowInterim passes the text a model sends along with its tool calls,
+	// e.g. "Let me read the file first", to the user as it arrives instead of
+	// only keeping it in the history.
+	ShowInterim bool
+	// CompactDuplicates replaces earlier tool results with a reference when
+	// the same tool returns the identical result again, e.g. when the model
+	// rereads a file, so the context holds only the latest copy.
+	CompactDuplicates bool
+	// DenyMessages override DefaultDenyMessages, the messages the model gets
+	// back when one of its tool calls is blocked.
+	DenyMessages map[DenyReason]string
+	// MaxResponseBytes caps how much of an assistant reply is kept in the
+	// history; the full reply is still shown. Zero disables the cap.
+	MaxResponseBytes int
+	// Out receives the conversation transcript: prompts, tool traces and
+	// command output. When nil, Run writes it to os.Stdout and Ask discards
+	// it. ErrOut receives warnings and defaults to os.Stderr.
+	Out    io.Writer
+	ErrOut io.Writer
+
+	caps         ModelCapabilities
+	selfDisabled map[string]bool
+	// processes are the background processes started by this agent.
+	processes *processTable
+	// modelMaxSteps is the step budget set through set_config; zero means
+	// MaxSteps applies.
+	modelMaxSteps int
+	results       resultStore
+	snapshot      *projectSnapshot
+	// root is ProjectRoot resolved by setup
+	root string
+	// interactive is set by Run; see out
+	interactive bool
+	// provider is the one the current turn uses, for tools that make
+	// auxiliary model calls such as summarize_file
+	provider Provider
 }
 
 func NewAgent(client *OllamaClient, user User) *Agent {
 	return &Agent{
-		client: client,
-		user:   user,
+		client:             client,
+		user:               user,
+		session:            NewSession(),
+		MaxSteps:           maxStepsFromEnv(),
+		MaxErrors:          defaultMaxErrors,
+		StepTimeout:        60 * time.Second,
+		ToolTimeout:        toolTimeoutFromEnv(),
+		StepCounter:        true,
+		StepLimitPrompt:    DefaultStepLimitPrompt,
+		DisabledTools:      disabledToolsFromEnv(),
+		EnabledTools:       toolListFromEnv("KUTAGENT_ENABLED_TOOLS"),
+		ErrOut:             os.Stderr,
+		MaxResponseBytes:   maxResponseBytesFromEnv(),
+		CompactDuplicates:  os.Getenv("KUTAGENT_COMPACT_DUPLICATES") != "",
+		ShowInterim:        os.Getenv("KUTAGENT_SHOW_INTERIM") != "",
+		ThinkingNotice:     thinkingNoticeFromEnv(),
+		ProjectRoot:        os.Getenv("KUTAGENT_PROJECT_ROOT"),
+		Limits:             DefaultLimits(),
+		SystemPrompt:       os.Getenv("OLLAMA_SYSTEM_PROMPT"),
+		Options:            modelOptionsFromEnv(),
+		SkipModelCheck:     os.Getenv("KUTAGENT_SKIP_MODEL_CHECK") != "",
+		CleanShellEnv:      os.Getenv("KUTAGENT_SHELL_CLEAN_ENV") != "",
+		ShowUsage:          os.Getenv("KUTAGENT_SHOW_USAGE") != "",
+		SessionPath:        os.Getenv("KUTAGENT_SESSION"),
+		MaxHistoryMessages: historyLimitFromEnv("KUTAGENT_MAX_HISTORY_MESSAGES"),
+		MaxHistoryTokens:   historyLimitFromEnv("KUTAGENT_MAX_HISTORY_TOKENS"),
+	}
+}
+
+// NewAgentWithHistory creates an agent whose conversation is seeded with
+// initial, e.g. a system prompt, few-shot examples or a resumed history.
+func NewAgentWithHistory(client *OllamaClient, user User, initial []UserMessage) (*Agent, error) {
+	if err := validateHistory(initial); err != nil {
+		return nil, err
+	}
+	agent := NewAgent(client, user)
+	agent.session.Messages = cloneMessages(initial)
+	return agent, nil
+}
+
+func validateHistory(msgs []UserMessage) error {
+	for i, m := range msgs {
+		switch m.Role {
+		case "system", "user", "assistant":
+		case "tool":
+			// Tool results only make sense as the answer to an assistant turn
+			if i == 0 || (msgs[i-1].Role != "assistant" && msgs[i-1].Role != "tool") {
+				return fmt.Errorf("message %d: tool message must follow an assistant message", i)
+			}
+		default:
+			return fmt.Errorf("message %d: invalid role %q", i, m.Role)
+		}
 	}
+	return nil
+}
+
+// Session returns the conversation session, which can be used to fork and
+// switch between branches of the conversation.
+func (agent *Agent) Session() *Session {
+	return agent.session
 }
 
 func (agent *Agent) Run(ctx context.Context) error {
-	conversations := []UserMessage{}
+	agent.interactive = true
+	provider, model, closeProvider, err := agent.setup()
+	if err != nil {
+		return err
+	}
+	defer closeProvider()
+	defer agent.StopProcesses()
+	if err := agent.checkModel(ctx, provider, model); err != nil {
+		return err
+	}
+	if err := agent.resumeSession(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(agent.out(), "Chat with "+model)
+
+	for {
+		fmt.Fprint(agent.out(), "\u001b[94mYou\u001b[0m: ")
+		message, ok, err := agent.readMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if agent.handleCommand(message) {
+			continue
+		}
+		reply, err := agent.turn(ctx, message, provider)
+		if err != nil {
+			return err
+		}
+		_ = agent.user.WriteMessage(reply)
+	}
+	return nil
+}
+
+// Ask sends message as the next user turn and returns the model's reply,
+// for using the agent as a library. Unlike Run it prints nothing unless Out
+// is set, and REPL commands are not interpreted. Background processes
+// started during the call keep running for later turns until
+// StopProcesses is called.
+func (agent *Agent) Ask(ctx context.Context, message string) (string, error) {
+	provider, _, closeProvider, err := agent.setup()
+	if err != nil {
+		return "", err
+	}
+	defer closeProvider()
+	return agent.turn(ctx, message, provider)
+}
+
+// StopProcesses stops the background processes this agent started with
+// start_process. Run calls it when the chat ends; library users of Ask
+// should call it once they are done with the agent.
+func (agent *Agent) StopProcesses() {
+	if agent.processes != nil {
+		agent.processes.stopAll()
+	}
+}
+
+// turn runs one user message through the model and records the exchange.
+func (agent *Agent) turn(ctx context.Context, message string, provider Provider) (_ string, err error) {
+	if agent.MetricsOut != nil {
+		metered := &meteredProvider{inner: provider}
+		provider = metered
+		defer func() { agent.emitMetrics(metered, err != nil) }()
+	}
+	agent.provider = provider
+	defer func() { agent.provider = nil }()
+	agent.session.Messages = append(agent.session.Messages, UserMessage{Role: "user", Content: message})
+
+	before := agent.session.Usage
+	reply, err := agent.runInference(ctx, agent.session.Messages, provider)
+	if agent.ShowUsage {
+		fmt.Fprintf(agent.out(), "usage: %s (session: %s)\n", formatUsage(agent.session.Usage.Sub(before)), formatUsage(agent.session.Usage))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	agent.session.Messages = append(agent.session.Messages, agent.capResponse(reply))
+	agent.session.touch()
+	agent.autosave()
+	return reply.Content, nil
+}
+
+// setup builds the provider from the environment and takes the session
+// snapshot if there isn't one yet. The returned func releases the provider.
+func (agent *Agent) setup() (Provider, string, func(), error) {
+	if agent.root == "" {
+		root, err := resolveProjectRoot(agent.ProjectRoot)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		agent.root = root
+	}
 
 	model := os.Getenv("OLLAMA_MODEL")
 	if model == "" {
@@ -45,28 +398,60 @@ func (agent *Agent) Run(ctx context.Context) error {
 		endpoint = "http://localhost:11434/api/chat"
 	}
 
-	provider := NewOllama(endpoint, model)
-
-	fmt.Println("Chat with " + model)
-
-	for {
-		fmt.Print("\u001b[94mYou\u001b[0m: ")
-		message, ok := agent.user.ReadMessage()
-		if !ok {
-			break
+	var provider Provider = NewOllama(endpoint, model)
+	closeProvider := func() {}
+	if path := os.Getenv("KUTAGENT_REPLAY"); path != "" {
+		replay, err := NewReplayProvider(path)
+		if err != nil {
+			return nil, "", nil, err
 		}
-		conversations = append(conversations, UserMessage{Role: "user", Content: message})
-
-		reply, err := agent.runInference(ctx, conversations, provider)
+		provider = replay
+	} else if path := os.Getenv("KUTAGENT_RECORD"); path != "" {
+		recorder, err := NewRecordingProvider(provider, path)
 		if err != nil {
-			return err
+			return nil, "", nil, err
+		}
+		closeProvider = func() { recorder.Close() }
+		provider = recorder
+	}
+	if fallbacks := os.Getenv("OLLAMA_FALLBACK_MODELS"); fallbacks != "" && agent.FallbackModels == nil {
+		for _, m := range strings.Split(fallbacks, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				agent.FallbackModels = append(agent.FallbackModels, m)
+			}
+		}
+	}
+	agent.caps = CapabilitiesFor(model)
+	if agent.MetricsOut == nil {
+		f, err := openMetricsFromEnv()
+		if err != nil {
+			return nil, "", nil, err
 		}
+		if f != nil {
+			// Kept open for the life of the agent, across Run and Ask calls
+			agent.MetricsOut = f
+		}
+	}
 
-		conversations = append(conversations, reply)
+	if agent.snapshot == nil {
+		// Remember the starting state of the project for session_diff
+		if snap, err := takeSnapshot(agent.root); err == nil {
+			agent.snapshot = snap
+		}
+	}
+	return provider, model, closeProvider, nil
+}
 
-		_ = agent.user.WriteMessage(reply.Content)
+// out is where the transcript goes: Out if set, otherwise stdout in the
+// REPL and nowhere when the agent is used through Ask.
+func (agent *Agent) out() io.Writer {
+	if agent.Out != nil {
+		return agent.Out
 	}
-	return nil
+	if agent.interactive {
+		return os.Stdout
+	}
+	return io.Discard
 }
 
 func (agent *Agent) runInference(ctx context.Context, conversations []UserMessage, provider Provider) (UserMessage, error) {
@@ -74,44 +459,242 @@ func (agent *Agent) runInference(ctx context.Context, conversations []UserMessag
 		return UserMessage{}, errors.New("conversations must not be empty")
 	}
 
-	tools := getToolsDefinition()
+	tools := agent.toolsDefinition()
 
-	// Create context with timeout to avoid hanging requests
-	if _, ok := ctx.Deadline(); !ok {
+	if agent.TurnTimeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, 60*time.Second)
+		ctx, cancel = context.WithTimeout(ctx, agent.TurnTimeout)
 		defer cancel()
 	}
 
 	messages := conversations
-	maxSteps := 5
-	for step := 0; step < maxSteps; step++ {
+	steps, errorsInRow := 0, 0
+	model, fallbacks := "", 0
+	loadRetried := false
+	var called []string
+	for steps < agent.maxSteps() {
 		reqBody := ProviderRequest{
+			Model:    model,
 			Stream:   false,
-			Messages: messages,
+			Messages: agent.trimHistory(messages),
 			Tools:    tools,
 		}
-		chatResp, err := provider.sendChatRequest(ctx, reqBody)
+		reqBody.Options = agent.requestOptions()
+		if agent.caps.NeedsToolsInPrompt {
+			prompt, err := toolsPrompt(tools)
+			if err != nil {
+				return UserMessage{}, err
+			}
+			reqBody.Messages = append([]UserMessage{prompt}, reqBody.Messages...)
+			reqBody.Tools = nil
+		}
+		reqBody.Messages = agent.withSystemPrompt(reqBody.Messages)
+		if agent.Prefill != "" {
+			reqBody.Messages = append(cloneMessages(reqBody.Messages), UserMessage{Role: "assistant", Content: agent.Prefill})
+		}
+		chatResp, err := agent.sendStep(ctx, provider, reqBody, agent.StepTimeout)
+		if err != nil && !loadRetried && ctx.Err() == nil && isModelLoading(err) {
+			loadRetried = true
+			fmt.Fprintln(agent.out(), "model is loading, please wait...")
+			chatResp, err = agent.sendStep(ctx, provider, reqBody, max(modelLoadTimeout, agent.StepTimeout))
+		}
 		if err != nil {
+			if reason := modelErrorReason(err); reason != "" && fallbacks < len(agent.FallbackModels) && fallbacks < maxFallbacks {
+				failed := "the primary model"
+				if model != "" {
+					failed = "model " + model
+				}
+				model = agent.FallbackModels[fallbacks]
+				fallbacks++
+				fmt.Fprintf(agent.ErrOut, "warning: %s failed (%s): %v; falling back to model %s\n", failed, reason, err, model)
+				continue
+			}
 			return UserMessage{}, err
 		}
+		applyCapabilities(agent.caps, &chatResp.Message, tools)
 
 		// There were tool calls, run them and return the result to LLM
 		if len(chatResp.Message.ToolCalls) > 0 {
-			messages = runTools(ctx, chatResp, messages)
+			for _, tc := range chatResp.Message.ToolCalls {
+				called = append(called, tc.Function.Name)
+			}
+			// Models often narrate what they are about to do alongside the calls
+			if interim := strings.TrimSpace(chatResp.Message.Content); agent.ShowInterim && interim != "" && agent.user != nil {
+				_ = agent.user.WriteMessage(interim)
+			}
+			var failed int
+			roundCtx, cancel := agent.toolRoundContext(ctx)
+			messages, failed = agent.runTools(roundCtx, steps+1, chatResp, messages)
+			cancel()
+			if failed > 0 {
+				errorsInRow++
+				if errorsInRow >= agent.maxErrors() {
+					return UserMessage{}, ErrTooManyToolErrors
+				}
+				continue
+			}
+			errorsInRow = 0
+			steps++
 			continue
 		}
 
 		// Final assistant message
 		if chatResp.Message.Content != "" {
-			return UserMessage{Role: chatResp.Message.Role, Content: chatResp.Message.Content}, nil
+			content := chatResp.Message.Content
+			// The model continues after the prefill; stitch the two together
+			if agent.Prefill != "" && !strings.HasPrefix(content, agent.Prefill) {
+				content = agent.Prefill + content
+			}
+			return UserMessage{Role: chatResp.Message.Role, Content: content}, nil
 		}
 
 		// If done but no content, return generic
 		if chatResp.Done {
 			return UserMessage{Role: "assistant", Content: ""}, nil
 		}
+		steps++
 	}
 
-	return UserMessage{}, fmt.Errorf("max tool-calling steps exceeded")
+	if agent.StepLimitPrompt == "" {
+		return UserMessage{}, fmt.Errorf("%w after %d steps; tools called: %s", ErrMaxSteps, steps, toolCallSummary(called))
+	}
+	return agent.summarizeAtStepLimit(ctx, messages, provider, model)
+}
+
+// withSystemPrompt puts the system prompt, if any, in front of msgs.
+func (agent *Agent) withSystemPrompt(msgs []UserMessage) []UserMessage {
+	if agent.SystemPrompt == "" {
+		return msgs
+	}
+	return append([]UserMessage{{Role: "system", Content: agent.SystemPrompt}}, msgs...)
+}
+
+// toolCallSummary lists tool names in the order first called, with a count
+// for repeats, e.g. "read_file x3, run_shell", to show where a turn looped.
+func toolCallSummary(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	counts := map[string]int{}
+	var order []string
+	for _, name := range names {
+		if counts[name] == 0 {
+			order = append(order, name)
+		}
+		counts[name]++
+	}
+	parts := make([]string, len(order))
+	for i, name := range order {
+		parts[i] = name
+		if counts[name] > 1 {
+			parts[i] += fmt.Sprintf(" x%d", counts[name])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// summarizeAtStepLimit makes one last provider call without tools so the
+// work done so far isn't thrown away when MaxSteps is hit. It goes to model,
+// the one the turn ended on, so a fallback isn't swapped back for the
+// primary model that failed.
+func (agent *Agent) summarizeAtStepLimit(ctx context.Context, messages []UserMessage, provider Provider, model string) (UserMessage, error) {
+	reqBody := ProviderRequest{
+		Model:    model,
+		Stream:   false,
+		Messages: agent.withSystemPrompt(append(cloneMessages(agent.trimHistory(messages)), UserMessage{Role: "user", Content: agent.StepLimitPrompt})),
+	}
+	reqBody.Options = agent.requestOptions()
+	chatResp, err := agent.sendStep(ctx, provider, reqBody, agent.StepTimeout)
+	if err != nil {
+		return UserMessage{}, fmt.Errorf("%w; summary request failed: %v", ErrMaxSteps, err)
+	}
+	return UserMessage{Role: "assistant", Content: chatResp.Message.Content}, nil
+}
+
+// defaultToolTimeout is used unless KUTAGENT_TOOL_TIMEOUT is set.
+const defaultToolTimeout = 5 * time.Minute
+
+func toolTimeoutFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("KUTAGENT_TOOL_TIMEOUT")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultToolTimeout
+}
+
+// defaultThinkingNotice is used unless KUTAGENT_THINKING_NOTICE is set.
+const defaultThinkingNotice = 10 * time.Second
+
+func thinkingNoticeFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("KUTAGENT_THINKING_NOTICE")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultThinkingNotice
+}
+
+// startThinkingNotice prints a notice if the request in flight takes longer
+// than ThinkingNotice. The returned func must be called once it completes.
+func (agent *Agent) startThinkingNotice() func() {
+	if agent.ThinkingNotice <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(agent.ThinkingNotice):
+			fmt.Fprintln(agent.out(), "still thinking... (model loading?)")
+		}
+	}()
+	return func() { close(done) }
+}
+
+// readMessage waits for the next user message, giving up when ctx is done
+// so an interrupt at the prompt ends the session.
+func (agent *Agent) readMessage(ctx context.Context) (string, bool, error) {
+	type input struct {
+		message string
+		ok      bool
+	}
+	ch := make(chan input, 1)
+	go func() {
+		message, ok := agent.user.ReadMessage()
+		ch <- input{message, ok}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", false, ctx.Err()
+	case in := <-ch:
+		return in.message, in.ok, nil
+	}
+}
+
+// sendStep makes one provider call bounded by timeout (zero means only ctx
+// bounds it), printing the thinking notice while it is slow, and adds its
+// usage to the session's.
+func (agent *Agent) sendStep(ctx context.Context, provider Provider, reqBody ProviderRequest, timeout time.Duration) (ProviderResponse, error) {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	stopNotice := agent.startThinkingNotice()
+	resp, err := provider.sendChatRequest(ctx, reqBody)
+	stopNotice()
+	if err == nil {
+		agent.session.Usage.Add(resp.Usage)
+	}
+	return resp, err
+}
+
+// toolRoundContext derives a fresh deadline for one round of tool calls
+// from the turn context.
+func (agent *Agent) toolRoundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := agent.ToolTimeout
+	if timeout <= 0 {
+		timeout = agent.StepTimeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
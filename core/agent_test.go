@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// repeatProvider answers every request with the same reply.
+type repeatProvider struct {
+	resp  ProviderResponse
+	calls int
+}
+
+func (p *repeatProvider) sendChatRequest(context.Context, ProviderRequest) (ProviderResponse, error) {
+	p.calls++
+	return p.resp, nil
+}
+
+func TestMaxErrors(t *testing.T) {
+	tests := []struct {
+		maxErrors, rounds int
+	}{
+		{0, defaultMaxErrors},
+		{1, 1},
+		{5, 5},
+	}
+	for _, tt := range tests {
+		provider := &repeatProvider{resp: toolRound(call("missing", nil))}
+		agent := &Agent{ErrOut: &bytes.Buffer{}, MaxErrors: tt.maxErrors, session: &Session{}}
+		_, err := agent.runInference(context.Background(), []UserMessage{{Role: "user", Content: "hello"}}, provider)
+		if !errors.Is(err, ErrTooManyToolErrors) {
+			t.Fatalf("MaxErrors %d: err = %v, want ErrTooManyToolErrors", tt.maxErrors, err)
+		}
+		if provider.calls != tt.rounds {
+			t.Errorf("MaxErrors %d: gave up after %d rounds, want %d", tt.maxErrors, provider.calls, tt.rounds)
+		}
+	}
+}
+
+// scriptProvider answers with replies in turn and records the requests.
+type scriptProvider struct {
+	replies  []ProviderResponse
+	requests []ProviderRequest
+}
+
+func (p *scriptProvider) sendChatRequest(_ context.Context, req ProviderRequest) (ProviderResponse, error) {
+	p.requests = append(p.requests, req)
+	if len(p.replies) == 0 {
+		return ProviderResponse{}, errors.New("no more replies")
+	}
+	resp := p.replies[0]
+	p.replies = p.replies[1:]
+	return resp, nil
+}
+
+func TestToolCallIDsUnique(t *testing.T) {
+	withRegistry(t, echoTool{"echo"})
+	final := ProviderResponse{}
+	final.Message = AgentMessage{Role: "assistant", Content: "done"}
+	provider := &scriptProvider{replies: []ProviderResponse{
+		toolRound(
+			withID(call("echo", map[string]any{"text": "a"}), "call_1"),
+			withID(call("echo", map[string]any{"text": "b"}), "call_1"),
+			call("echo", map[string]any{"text": "c"}),
+		),
+		final,
+	}}
+	var errOut bytes.Buffer
+	agent := &Agent{ErrOut: &errOut, session: &Session{}, caps: defaultCapabilities}
+	if _, err := agent.runInference(context.Background(), []UserMessage{{Role: "user", Content: "hello"}}, provider); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	var contents []string
+	for _, m := range provider.requests[1].Messages {
+		if m.Role != "tool" {
+			continue
+		}
+		if m.ToolCallID == "" || seen[m.ToolCallID] {
+			t.Errorf("tool message %q has id %q, want a unique non-empty one", m.Content, m.ToolCallID)
+		}
+		seen[m.ToolCallID] = true
+		contents = append(contents, m.Content)
+	}
+	if len(contents) != 3 || !seen["call_1"] {
+		t.Errorf("tool messages %q with ids %v, want three keeping the first call_1", contents, seen)
+	}
+	if !strings.Contains(errOut.String(), `duplicate tool call id "call_1"`) {
+		t.Errorf("warnings = %q, want the duplicate reported", errOut.String())
+	}
+}
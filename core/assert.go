@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// assertTailBytes is how much of the output a failed assertion shows.
+const assertTailBytes = 2048
+
+// CommandExpectations are the checks assert_command applies to a command.
+type CommandExpectations struct {
+	ExitCode    int
+	Contains    []string
+	NotContains []string
+}
+
+// assertCommand runs cmdStr via sh -c in dir and checks its exit code and combined
+// output against want. A failed check is a FAIL result, not an error.
+func assertCommand(ctx context.Context, dir, cmdStr string, timeout time.Duration, want CommandExpectations) (string, error) {
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, "sh", "-c", cmdStr)
+	cmd.Dir = dir
+	cmd.WaitDelay = 2 * time.Second
+	var out strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	exitCode := 0
+	if err != nil {
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			return "", fmt.Errorf("run command: %w", err)
+		}
+		exitCode = ee.ExitCode()
+	}
+	output := out.String()
+
+	var failures []string
+	if errors.Is(cctx.Err(), context.DeadlineExceeded) {
+		failures = append(failures, fmt.Sprintf("command timed out after %s", timeout))
+	} else if exitCode != want.ExitCode {
+		failures = append(failures, fmt.Sprintf("exit code: expected %d, got %d", want.ExitCode, exitCode))
+	}
+	for _, s := range want.Contains {
+		if !strings.Contains(output, s) {
+			failures = append(failures, fmt.Sprintf("output does not contain %q", s))
+		}
+	}
+	for _, s := range want.NotContains {
+		if strings.Contains(output, s) {
+			failures = append(failures, fmt.Sprintf("output contains %q", s))
+		}
+	}
+	if len(failures) == 0 {
+		return fmt.Sprintf("PASS exit_code=%d", exitCode), nil
+	}
+	tail := output
+	if len(tail) > assertTailBytes {
+		tail = "..." + tail[len(tail)-assertTailBytes:]
+	}
+	return fmt.Sprintf("FAIL\n- %s\noutput:\n%s", strings.Join(failures, "\n- "), tail), nil
+}
+
+// stringListArg reads an argument that may be a single string or a list.
+func stringListArg(args map[string]any, key string) []string {
+	switch v := args[key].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssertCommand(t *testing.T) {
+	root, _ := newRoot(t)
+	ctx := withProjectRoot(context.Background(), root)
+	tool, _ := DefaultRegistry.Lookup("assert_command")
+	tests := []struct {
+		name string
+		args map[string]any
+		want []string // the result starts with the first and contains the rest
+	}{
+		{"exit code pass", map[string]any{"command": "true"}, []string{"PASS exit_code=0"}},
+		{"exit code fail", map[string]any{"command": "exit 3"}, []string{"FAIL", "exit code: expected 0, got 3"}},
+		{"expected nonzero exit", map[string]any{"command": "exit 2", "expect_exit_code": float64(2)}, []string{"PASS exit_code=2"}},
+		{"contains pass", map[string]any{"command": "echo ok; echo done >&2", "expect_contains": []any{"ok", "done"}}, []string{"PASS"}},
+		{"contains fail", map[string]any{"command": "echo ok", "expect_contains": "missing"}, []string{"FAIL", `output does not contain "missing"`, "output:\nok"}},
+		{"not contains pass", map[string]any{"command": "echo ok", "expect_not_contains": "panic"}, []string{"PASS"}},
+		{"not contains fail", map[string]any{"command": "echo panic: boom", "expect_not_contains": []any{"panic"}}, []string{"FAIL", `output contains "panic"`}},
+		{"every failure listed", map[string]any{"command": "echo panic; exit 1", "expect_contains": "ok", "expect_not_contains": "panic"}, []string{
+			"FAIL", "exit code: expected 0, got 1", `output does not contain "ok"`, `output contains "panic"`,
+		}},
+		{"timeout", map[string]any{"command": "exec sleep 5", "timeout_sec": float64(1)}, []string{"FAIL", "command timed out after 1s"}},
+		{"runs in the root", map[string]any{"command": "pwd", "expect_contains": root}, []string{"PASS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tool.Execute(ctx, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tt.want[0]) {
+				t.Errorf("result = %q, want it to start with %q", got, tt.want[0])
+			}
+			for _, w := range tt.want[1:] {
+				if !strings.Contains(got, w) {
+					t.Errorf("result = %q, want it to contain %q", got, w)
+				}
+			}
+		})
+	}
+	if _, err := tool.Execute(ctx, map[string]any{}); err == nil || err.Error() != "missing required argument: command" {
+		t.Errorf("without a command: err = %v", err)
+	}
+}
+
+func TestAssertCommandOutputTail(t *testing.T) {
+	got, err := assertCommand(context.Background(), t.TempDir(), "yes x | head -c 5000; echo END", 5*time.Second, CommandExpectations{Contains: []string{"missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, output, _ := strings.Cut(got, "output:\n")
+	if !strings.HasPrefix(output, "...") || !strings.HasSuffix(output, "END\n") || len(output) != len("...")+assertTailBytes {
+		t.Errorf("output of a long failure = %d bytes %q..., want the last %d bytes", len(output), output[:min(len(output), 20)], assertTailBytes)
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxBookmarks    = 50
+	maxBookmarkNote = 500
+)
+
+// Bookmark is a file location the model or user wants to come back to.
+type Bookmark struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Note string `json:"note,omitempty"`
+}
+
+// Bookmarks are kept in the order they were added.
+type Bookmarks []Bookmark
+
+func (b Bookmarks) String() string {
+	if len(b) == 0 {
+		return "no bookmarks"
+	}
+	var sb strings.Builder
+	for _, bm := range b {
+		fmt.Fprintf(&sb, "%s: %s", bm.Name, bm.Path)
+		if bm.Note != "" {
+			fmt.Fprintf(&sb, " - %s", bm.Note)
+		}
+		sb.WriteByte('\n')
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Add saves a bookmark for path, relative to root, replacing any bookmark
+// with the same name.
+func (b *Bookmarks) Add(root, name, path, note string) error {
+	name, note = strings.TrimSpace(name), strings.TrimSpace(note)
+	if name == "" {
+		return fmt.Errorf("missing required argument: name")
+	}
+	if path == "" {
+		return fmt.Errorf("missing required argument: path")
+	}
+	if r := []rune(note); len(r) > maxBookmarkNote {
+		note = string(r[:maxBookmarkNote])
+	}
+	joined, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, joined)
+	if err != nil {
+		return fmt.Errorf("relative path: %w", err)
+	}
+	bm := Bookmark{Name: name, Path: filepath.ToSlash(rel), Note: note}
+	for i := range *b {
+		if (*b)[i].Name == name {
+			(*b)[i] = bm
+			return nil
+		}
+	}
+	if len(*b) >= maxBookmarks {
+		return fmt.Errorf("too many bookmarks (limit %d); reuse a name to replace one", maxBookmarks)
+	}
+	*b = append(*b, bm)
+	return nil
+}
+
+// bookmarkTools are the tools for the session's bookmarks.
+func (agent *Agent) bookmarkTools() []builtinTool {
+	return []builtinTool{
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "bookmark_add",
+					Description: "Remember an important file or directory under a short name, with an optional note on why it matters, so it can be found again without searching. Reusing a name replaces that bookmark. Input: { name: string, path: string, note?: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"name": map[string]any{"type": "string"},
+							"path": map[string]any{"type": "string"},
+							"note": map[string]any{"type": "string"},
+						},
+						"required":             []string{"name", "path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.bookmarkAddTool,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "bookmark_list",
+					Description: "List the bookmarked locations with their notes",
+					Parameters: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.bookmarkListTool,
+		},
+	}
+}
+
+func (agent *Agent) bookmarkAddTool(ctx context.Context, args map[string]any) (string, error) {
+	bmName, _ := args["name"].(string)
+	path, _ := args["path"].(string)
+	note, _ := args["note"].(string)
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := agent.session.Bookmarks.Add(root, bmName, path, note); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("bookmarked %s (%d of %d)", strings.TrimSpace(bmName), len(agent.session.Bookmarks), maxBookmarks), nil
+}
+
+func (agent *Agent) bookmarkListTool(ctx context.Context, args map[string]any) (string, error) {
+	return agent.session.Bookmarks.String(), nil
+}
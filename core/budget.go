@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// bytesPerToken is a rough average for English text and code; without the
+// model's tokenizer an estimate is the best we can do.
+const bytesPerToken = 4
+
+func estimateTokens(s string) int {
+	return (len(s) + bytesPerToken - 1) / bytesPerToken
+}
+
+// BudgetReport breaks down where the conversation's context is spent.
+type BudgetReport struct {
+	Messages        int            `json:"messages"`
+	EstimatedTokens int            `json:"estimated_tokens"`
+	ByRole          map[string]int `json:"estimated_tokens_by_role"`
+	// NumCtx is the context size from the num_ctx model option, 0 if unset.
+	NumCtx          int     `json:"num_ctx,omitempty"`
+	PercentOfNumCtx float64 `json:"percent_of_num_ctx,omitempty"`
+}
+
+// budgetReport estimates the tokens used by the current branch of the
+// conversation, split into system prompt, dialogue and tool results.
+func (agent *Agent) budgetReport() BudgetReport {
+	report := BudgetReport{ByRole: map[string]int{"system": 0, "user": 0, "assistant": 0, "tool": 0}}
+	for _, m := range agent.withSystemPrompt(agent.session.Messages) {
+		tokens := estimateTokens(m.Content)
+		report.Messages++
+		report.EstimatedTokens += tokens
+		report.ByRole[m.Role] += tokens
+	}
+	switch n := agent.Options["num_ctx"].(type) {
+	case int:
+		report.NumCtx = n
+	case float64:
+		report.NumCtx = int(n)
+	}
+	if report.NumCtx > 0 {
+		pct := float64(report.EstimatedTokens) * 100 / float64(report.NumCtx)
+		report.PercentOfNumCtx = float64(int(pct*10)) / 10
+	}
+	return report
+}
+
+func budgetReportDef() ToolDef {
+	return ToolDef{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "budget_report",
+			Description: "Estimate how many tokens the conversation so far uses, split by system prompt, user, assistant and tool results, and how close it is to num_ctx when that is set. Results of the current turn's tool calls are not yet included.",
+			Parameters: map[string]any{
+				"type":                 "object",
+				"properties":           map[string]any{},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
+
+func (agent *Agent) budgetReportTool(ctx context.Context, _ map[string]any) (string, error) {
+	return agent.budgetReportJSON(ctx)
+}
+
+func (agent *Agent) budgetReportJSON(_ context.Context) (string, error) {
+	out, err := json.MarshalIndent(agent.budgetReport(), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal budget report: %w", err)
+	}
+	return string(out), nil
+}
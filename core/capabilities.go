@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ModelCapabilities describes how a model copes with tool calling, so the
+// agent can work around the quirks of individual models.
+type ModelCapabilities struct {
+	// SupportsParallelTools is false for models that get confused when more
+	// than one tool call is answered in a single round. Only their first
+	// call is run; the others are answered with notRunParallel.
+	SupportsParallelTools bool
+	// NeedsToolsInPrompt models ignore the tools field of the request and
+	// need the definitions spelled out in a system message instead.
+	NeedsToolsInPrompt bool
+	// ArgsAsString models send non-string arguments (numbers, booleans,
+	// objects) encoded as strings.
+	ArgsAsString bool
+	// FoldToolResults models get the results of a round of tool calls as a
+	// single tool message instead of one message per call.
+	FoldToolResults bool
+}
+
+// notRunParallel answers the calls after the first of a round for models
+// without SupportsParallelTools, so the model knows to make them again.
+const notRunParallel = "not run: this model supports one tool call per turn; call it again"
+
+var defaultCapabilities = ModelCapabilities{SupportsParallelTools: true}
+
+// ModelCapabilityTable maps a model family (the model name without its tag)
+// to its capabilities. The longest matching prefix wins.
+var ModelCapabilityTable = map[string]ModelCapabilities{
+	"qwen3":    {SupportsParallelTools: true},
+	"qwen2.5":  {SupportsParallelTools: true},
+	"llama3.1": {SupportsParallelTools: false},
+	"llama3.2": {SupportsParallelTools: false, ArgsAsString: true},
+	"mistral":  {SupportsParallelTools: false, ArgsAsString: true},
+}
+
+// CapabilitiesFor looks up the capabilities of model, e.g. "qwen3:8b".
+func CapabilitiesFor(model string) ModelCapabilities {
+	name := strings.ToLower(model)
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	best, caps := -1, defaultCapabilities
+	for prefix, c := range ModelCapabilityTable {
+		if strings.HasPrefix(name, prefix) && len(prefix) > best {
+			best, caps = len(prefix), c
+		}
+	}
+	return caps
+}
+
+// toolsPrompt describes tools in a system message for models that need it.
+func toolsPrompt(tools []ToolDef) (UserMessage, error) {
+	defs, err := json.MarshalIndent(tools, "", "  ")
+	if err != nil {
+		return UserMessage{}, fmt.Errorf("marshal tools: %w", err)
+	}
+	content := "You can call the following tools. To call one, reply with a tool call " +
+		"naming the function and giving its arguments as a JSON object.\n" + string(defs)
+	return UserMessage{Role: "system", Content: content}, nil
+}
+
+// coerceArgs decodes string-encoded arguments into the type declared for
+// them in the tool's parameter schema.
+func coerceArgs(args map[string]any, def FunctionDef) {
+	props, _ := def.Parameters["properties"].(map[string]any)
+	for key, v := range args {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		prop, _ := props[key].(map[string]any)
+		switch prop["type"] {
+		case "integer", "number":
+			if f, err := strconv.ParseFloat(str, 64); err == nil {
+				args[key] = f
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(str); err == nil {
+				args[key] = b
+			}
+		case "object", "array":
+			var decoded any
+			if err := json.Unmarshal([]byte(str), &decoded); err == nil {
+				args[key] = decoded
+			}
+		}
+	}
+}
+
+// applyCapabilities adjusts the tool calls of a response to what the model
+// is known to get wrong. Calls beyond the first for models without
+// SupportsParallelTools are answered by runTools instead of run.
+func applyCapabilities(caps ModelCapabilities, msg *AgentMessage, tools []ToolDef) {
+	if caps.ArgsAsString {
+		for i := range msg.ToolCalls {
+			tc := &msg.ToolCalls[i]
+			for _, t := range tools {
+				if t.Function.Name == tc.Function.Name && tc.Function.Arguments != nil {
+					coerceArgs(tc.Function.Arguments, t.Function)
+				}
+			}
+		}
+	}
+}
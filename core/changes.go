@@ -0,0 +1,133 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxChanges bounds how many edits one apply_changes call may make.
+const maxChanges = 50
+
+// fileChange replaces the single occurrence of Old in the file at Path
+// with New.
+type fileChange struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// pendingFile is a file's original and edited content during apply_changes.
+type pendingFile struct {
+	path     string // as first given, for messages
+	abs      string
+	perm     os.FileMode
+	original string
+	edited   string
+}
+
+// parseChanges reads the changes argument of apply_changes.
+func parseChanges(args map[string]any) ([]fileChange, error) {
+	list, _ := args["changes"].([]any)
+	if len(list) == 0 {
+		return nil, fmt.Errorf("missing required argument: changes")
+	}
+	if len(list) > maxChanges {
+		return nil, fmt.Errorf("too many changes: %d (limit %d)", len(list), maxChanges)
+	}
+	changes := make([]fileChange, 0, len(list))
+	for i, item := range list {
+		m, _ := item.(map[string]any)
+		c := fileChange{}
+		c.Path, _ = m["path"].(string)
+		c.Old, _ = m["old"].(string)
+		c.New, _ = m["new"].(string)
+		if c.Path == "" || c.Old == "" {
+			return nil, fmt.Errorf("change %d: path and old are required", i+1)
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// applyChanges checks every change against the files before writing any of
+// them, then writes the edited files. If a write fails, the files already
+// written are restored, so the project is never left half-edited. Several
+// changes to one file apply in order, each to the result of the previous,
+// however its path is spelled.
+func applyChanges(root string, changes []fileChange) (string, error) {
+	files := map[string]*pendingFile{}
+	var order []string
+	summary := make([]string, 0, len(changes))
+	for i, c := range changes {
+		abs, err := resolveWithinRoot(root, c.Path)
+		if err != nil {
+			return "", fmt.Errorf("change %d (%s): %w", i+1, c.Path, err)
+		}
+		f, ok := files[abs]
+		if !ok {
+			fi, err := os.Stat(abs)
+			if err != nil {
+				return "", fmt.Errorf("change %d (%s): %w", i+1, c.Path, err)
+			}
+			if fi.IsDir() {
+				return "", fmt.Errorf("change %d (%s): path is a directory", i+1, c.Path)
+			}
+			data, err := os.ReadFile(abs)
+			if err != nil {
+				return "", fmt.Errorf("change %d (%s): read file: %w", i+1, c.Path, err)
+			}
+			f = &pendingFile{path: c.Path, abs: abs, perm: fi.Mode().Perm(), original: string(data), edited: string(data)}
+			files[abs] = f
+			order = append(order, abs)
+		}
+		switch n := strings.Count(f.edited, c.Old); n {
+		case 0:
+			return "", fmt.Errorf("change %d (%s): old text not found; nothing was written", i+1, c.Path)
+		case 1:
+		default:
+			return "", fmt.Errorf("change %d (%s): old text occurs %d times, it must be unique; nothing was written", i+1, c.Path, n)
+		}
+		line := strings.Count(f.edited[:strings.Index(f.edited, c.Old)], "\n") + 1
+		f.edited = strings.Replace(f.edited, c.Old, c.New, 1)
+		summary = append(summary, fmt.Sprintf("%d. %s: line %d, -%d +%d lines", i+1, c.Path, line, countLines(c.Old), countLines(c.New)))
+	}
+
+	var written []*pendingFile
+	for _, abs := range order {
+		f := files[abs]
+		if err := writeFileAtomic(f.abs, []byte(f.edited), f.perm); err != nil {
+			rollbackErr := rollbackFiles(written)
+			if rollbackErr != nil {
+				return "", fmt.Errorf("write %s: %w; rollback failed: %v", f.path, err, rollbackErr)
+			}
+			return "", fmt.Errorf("write %s: %w; all changes were rolled back", f.path, err)
+		}
+		written = append(written, f)
+	}
+	return fmt.Sprintf("applied %d changes to %d files\n%s", len(changes), len(order), strings.Join(summary, "\n")), nil
+}
+
+// rollbackFiles restores the original content of files.
+func rollbackFiles(files []*pendingFile) error {
+	var failed []string
+	for _, f := range files {
+		if err := writeFileAtomic(f.abs, []byte(f.original), f.perm); err != nil {
+			failed = append(failed, f.abs)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not restore %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// countLines counts the lines of s, including a last line without a
+// trailing newline.
+func countLines(s string) int {
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyChangesSameFileDifferentSpellings(t *testing.T) {
+	root, _ := newRoot(t)
+	path := filepath.Join(root, "sub", "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, "sub", filepath.Join(root, "in"))
+	changes := []fileChange{
+		{Path: "sub/a.txt", Old: "one", New: "1"},
+		{Path: "./sub/../sub/a.txt", Old: "two", New: "2"},
+		{Path: "in/a.txt", Old: "1\n2", New: "1\n2\nthree"},
+	}
+	out, err := applyChanges(root, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "applied 3 changes to 1 files") {
+		t.Errorf("applyChanges() = %q", out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "1\n2\nthree\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestApplyChangesNothingWrittenOnFailure(t *testing.T) {
+	root, _ := newRoot(t)
+	a := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(a, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changes := []fileChange{
+		{Path: "a.txt", Old: "keep", New: "changed"},
+		{Path: "a.txt", Old: "missing", New: "x"},
+	}
+	if _, err := applyChanges(root, changes); err == nil {
+		t.Fatal("applyChanges succeeded with a change whose old text is missing")
+	}
+	if data, _ := os.ReadFile(a); string(data) != "keep" {
+		t.Errorf("file = %q after a failed apply, want it unchanged", data)
+	}
+}
@@ -0,0 +1,9 @@
+package core
+
+import "time"
+
+// Now tells tools and session metadata the current time. Replace it with a
+// fixed clock to make time_now and timestamps deterministic, e.g. in tests
+// or when replaying a recorded session. Elapsed-time measurements and
+// timeouts always use the real clock.
+var Now = time.Now
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stubClock makes Now return the times in turn, repeating the last, for
+// the rest of the test.
+func stubClock(t *testing.T, times ...time.Time) {
+	t.Helper()
+	saved := Now
+	Now = func() time.Time {
+		now := times[0]
+		if len(times) > 1 {
+			times = times[1:]
+		}
+		return now
+	}
+	t.Cleanup(func() { Now = saved })
+}
+
+func TestTimeNowUsesClock(t *testing.T) {
+	stubClock(t, time.Date(2024, 2, 29, 13, 45, 0, 0, time.FixedZone("CET", 3600)))
+	tool, _ := DefaultRegistry.Lookup("time_now")
+	got, err := tool.Execute(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-02-29T13:45:00+01:00"; got != want {
+		t.Errorf("time_now = %q, want %q", got, want)
+	}
+}
+
+func TestSessionTimestampsUseClock(t *testing.T) {
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	stubClock(t, created, updated)
+	s := NewSession()
+	s.touch()
+	meta := s.Metadata()
+	if !meta.Created.Equal(created) || !meta.Updated.Equal(updated) {
+		t.Errorf("created, updated = %v, %v; want %v, %v", meta.Created, meta.Updated, created, updated)
+	}
+}
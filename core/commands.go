@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// handleCommand runs a REPL command such as "/fork name". It reports whether
+// the line was a command, in which case it must not be sent to the model.
+func (agent *Agent) handleCommand(line string) bool {
+	if !strings.HasPrefix(line, "/") {
+		return false
+	}
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "/fork":
+		if len(args) != 1 {
+			fmt.Fprintln(agent.out(), "usage: /fork <name>")
+			return true
+		}
+		if err := agent.session.Fork(args[0]); err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintf(agent.out(), "forked to branch %s\n", args[0])
+	case "/switch":
+		if len(args) != 1 {
+			fmt.Fprintln(agent.out(), "usage: /switch <name>")
+			return true
+		}
+		if err := agent.session.Switch(args[0]); err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintf(agent.out(), "switched to branch %s (%d messages)\n", args[0], len(agent.session.Messages))
+	case "/branches":
+		for _, name := range agent.session.Branches() {
+			marker := "  "
+			if name == agent.session.Current() {
+				marker = "* "
+			}
+			fmt.Fprintln(agent.out(), marker+name)
+		}
+	case "/reset":
+		agent.session.Reset()
+		fmt.Fprintln(agent.out(), "conversation and plan cleared")
+	case "/plan":
+		fmt.Fprintln(agent.out(), agent.session.Plan.String())
+	case "/bookmarks":
+		fmt.Fprintln(agent.out(), agent.session.Bookmarks.String())
+	case "/bookmark":
+		if len(args) < 2 {
+			fmt.Fprintln(agent.out(), "usage: /bookmark <name> <path> [note]")
+			return true
+		}
+		root, err := projectRoot(agent.toolContext(context.Background()))
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		if err := agent.session.Bookmarks.Add(root, args[0], args[1], strings.Join(args[2:], " ")); err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintf(agent.out(), "bookmarked %s\n", args[0])
+	case "/diff":
+		out, err := agent.sessionDiff(context.Background(), len(args) > 0 && args[0] == "-v")
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintln(agent.out(), out)
+	case "/usage":
+		fmt.Fprintln(agent.out(), "usage:", formatUsage(agent.session.Usage))
+	case "/save":
+		path := agent.SessionPath
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			fmt.Fprintln(agent.out(), "usage: /save <path>")
+			return true
+		}
+		if err := agent.SaveSession(path); err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintf(agent.out(), "saved session to %s\n", path)
+	case "/load":
+		if len(args) != 1 {
+			fmt.Fprintln(agent.out(), "usage: /load <path>")
+			return true
+		}
+		if err := agent.LoadSession(args[0]); err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintf(agent.out(), "loaded session %s (%d messages)\n", args[0], len(agent.session.Messages))
+	case "/sessions":
+		if len(args) == 0 || len(args) > 2 {
+			fmt.Fprintln(agent.out(), "usage: /sessions <dir> [tag]")
+			return true
+		}
+		tag := ""
+		if len(args) == 2 {
+			tag = args[1]
+		}
+		infos, err := ListSessions(args[0], tag)
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		if len(infos) == 0 {
+			fmt.Fprintln(agent.out(), "no sessions")
+		}
+		for _, info := range infos {
+			fmt.Fprintf(agent.out(), "%s  %s  %q (%d messages) %v\n", info.Path, info.Metadata.Updated.Format("2006-01-02 15:04"), info.Metadata.Title, info.Messages, info.Metadata.Tags)
+		}
+	case "/budget":
+		out, err := agent.budgetReportJSON(context.Background())
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintln(agent.out(), out)
+	case "/tools":
+		// Exactly what the model is offered, after disabled tools are removed
+		out, err := json.MarshalIndent(agent.toolsDefinition(), "", "  ")
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintln(agent.out(), string(out))
+	case "/config":
+		out, err := json.MarshalIndent(agent.configSnapshot(), "", "  ")
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintln(agent.out(), string(out))
+	case "/set":
+		if len(args) == 0 && len(agent.Options) == 0 {
+			fmt.Fprintln(agent.out(), "model options: none set")
+			return true
+		}
+		if len(args) == 0 {
+			out, err := json.MarshalIndent(agent.Options, "", "  ")
+			if err != nil {
+				fmt.Fprintln(agent.out(), err)
+				return true
+			}
+			fmt.Fprintln(agent.out(), "model options: "+string(out))
+			return true
+		}
+		if len(args) < 2 {
+			fmt.Fprintln(agent.out(), "usage: /set <key> <value>")
+			return true
+		}
+		key, value := args[0], strings.Join(args[1:], " ")
+		var err error
+		if _, ok := modelOptions[key]; ok {
+			err = agent.setModelOption(key, value)
+		} else {
+			err = agent.setConfig(key, value)
+		}
+		if err != nil {
+			fmt.Fprintln(agent.out(), err)
+			return true
+		}
+		fmt.Fprintf(agent.out(), "%s set to %s\n", key, value)
+	case "/reset-options":
+		// Back to the state the agent started in, OLLAMA_<KEY> defaults included
+		agent.Options = modelOptionsFromEnv()
+		fmt.Fprintln(agent.out(), "model options reset to their defaults")
+	default:
+		fmt.Fprintf(agent.out(), "unknown command: %s\n", cmd)
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configTools are get_config and set_config, which are only offered to
+// the model when Agent.ConfigTools is enabled.
+func (agent *Agent) configTools() []builtinTool {
+	return []builtinTool{
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "get_config",
+					Description: "Return the agent's runtime settings (temperature, max_steps, disabled_tools) as JSON",
+					Parameters: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.getConfigTool,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "set_config",
+					Description: "Change a runtime setting for the following turns. Keys: temperature (0-2), max_steps (from 1 up to the limit set by the operator), disable_tool and enable_tool (a tool name). Input: { key: string, value: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"key":   map[string]any{"type": "string"},
+							"value": map[string]any{"type": "string"},
+						},
+						"required":             []string{"key", "value"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.setConfigTool,
+		},
+	}
+}
+
+func (agent *Agent) getConfigTool(_ context.Context, args map[string]any) (string, error) {
+	out, err := json.MarshalIndent(agent.configSnapshot(), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal config: %w", err)
+	}
+	return string(out), nil
+}
+
+func (agent *Agent) setConfigTool(_ context.Context, args map[string]any) (string, error) {
+	key, _ := args["key"].(string)
+	if key == "" {
+		return "", fmt.Errorf("missing required argument: key")
+	}
+	value := fmt.Sprint(args["value"])
+	var err error
+	if key == "max_steps" {
+		err = agent.setModelMaxSteps(value)
+	} else {
+		err = agent.setConfig(key, value)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s updated", key), nil
+}
+
+// setModelMaxSteps lets the model lower its step budget, or raise it again
+// up to the MaxSteps set by the operator, but never beyond that.
+func (agent *Agent) setModelMaxSteps(value string) error {
+	limit := agent.MaxSteps
+	if limit <= 0 {
+		limit = defaultMaxSteps
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > limit {
+		return fmt.Errorf("max_steps must be an integer between 1 and %d", limit)
+	}
+	agent.modelMaxSteps = n
+	return nil
+}
+
+func (agent *Agent) configSnapshot() map[string]any {
+	disabled := []string{}
+	for name := range agent.DisabledTools {
+		disabled = append(disabled, name)
+	}
+	for name := range agent.selfDisabled {
+		disabled = append(disabled, name)
+	}
+	sort.Strings(disabled)
+	return map[string]any{
+		"temperature":    agent.Options["temperature"],
+		"max_steps":      agent.maxSteps(),
+		"disabled_tools": disabled,
+	}
+}
+
+// setConfig changes one runtime-tunable setting. Only a small set of keys is
+// accepted so the model can't lift limits set by the operator: tools can be
+// disabled, but only tools disabled this way can be enabled again.
+func (agent *Agent) setConfig(key, value string) error {
+	switch key {
+	case "temperature":
+		return agent.setModelOption(key, value)
+	case "max_steps":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 20 {
+			return fmt.Errorf("max_steps must be an integer between 1 and 20")
+		}
+		agent.MaxSteps = n
+		agent.modelMaxSteps = 0
+	case "disable_tool":
+		if agent.selfDisabled == nil {
+			agent.selfDisabled = map[string]bool{}
+		}
+		agent.selfDisabled[value] = true
+	case "enable_tool":
+		if !agent.selfDisabled[value] {
+			return fmt.Errorf("tool %q was not disabled at runtime and can't be enabled (%w)", value, errSettingLocked)
+		}
+		delete(agent.selfDisabled, value)
+	default:
+		return fmt.Errorf("setting %q can't be changed at runtime (%w)", key, errSettingLocked)
+	}
+	return nil
+}
+
+// modelOptions are the Ollama model options that can be set from the REPL,
+// with the type of their value.
+var modelOptions = map[string]string{
+	"temperature":    "float",
+	"top_p":          "float",
+	"min_p":          "float",
+	"repeat_penalty": "float",
+	"top_k":          "int",
+	"num_ctx":        "int",
+	"num_predict":    "int",
+	"seed":           "int",
+	"stop":           "string",
+}
+
+// setModelOption validates value against the option's type and stores it in
+// Options, where it stays for every following turn.
+func (agent *Agent) setModelOption(key, value string) error {
+	v, err := parseModelOption(key, value)
+	if err != nil {
+		return err
+	}
+	if agent.Options == nil {
+		agent.Options = map[string]any{}
+	}
+	agent.Options[key] = v
+	return nil
+}
+
+// parseModelOption converts value to the type of the model option key.
+func parseModelOption(key, value string) (any, error) {
+	var v any
+	switch modelOptions[key] {
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number", key)
+		}
+		if key == "temperature" && f > 2 {
+			return nil, fmt.Errorf("temperature must be a number between 0 and 2")
+		}
+		v = f
+	case "int":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", key)
+		}
+		// num_predict -1 means unlimited; seed may be any value
+		if n < 0 && key != "seed" && !(key == "num_predict" && n == -1) {
+			return nil, fmt.Errorf("%s must not be negative", key)
+		}
+		v = n
+	case "string":
+		// Ollama takes a list of stop sequences
+		v = []string{value}
+	default:
+		return nil, fmt.Errorf("unknown model option %q", key)
+	}
+	return v, nil
+}
+
+// modelOptionsFromEnv reads default model options from OLLAMA_<KEY>
+// variables, e.g. OLLAMA_TEMPERATURE or OLLAMA_NUM_CTX. Invalid values are
+// reported and skipped so Ollama's own default applies.
+func modelOptionsFromEnv() map[string]any {
+	var opts map[string]any
+	for key := range modelOptions {
+		name := "OLLAMA_" + strings.ToUpper(key)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		v, err := parseModelOption(key, value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring %s: %v\n", name, err)
+			continue
+		}
+		if opts == nil {
+			opts = map[string]any{}
+		}
+		opts[key] = v
+	}
+	return opts
+}
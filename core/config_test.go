@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSetConfigCapsMaxSteps(t *testing.T) {
+	agent := &Agent{MaxSteps: 8}
+	set := func(value string) error {
+		_, err := agent.setConfigTool(context.Background(), map[string]any{"key": "max_steps", "value": value})
+		return err
+	}
+
+	for _, value := range []string{"9", "20", "0", "x"} {
+		if err := set(value); err == nil || !strings.Contains(err.Error(), "between 1 and 8") {
+			t.Errorf("set_config max_steps %s: err = %v, want it rejected", value, err)
+		}
+	}
+	if got := agent.maxSteps(); got != 8 {
+		t.Errorf("maxSteps() = %d after rejected changes, want 8", got)
+	}
+
+	if err := set("3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := agent.maxSteps(); got != 3 || agent.MaxSteps != 8 {
+		t.Errorf("maxSteps() = %d, MaxSteps = %d; want 3 and the operator's 8 kept", agent.maxSteps(), agent.MaxSteps)
+	}
+	// Lowered once, the model may raise it back up to the operator's limit
+	if err := set("8"); err != nil {
+		t.Errorf("set_config max_steps 8: %v", err)
+	}
+
+	// The operator can go higher, which lifts the model's limit too
+	if err := agent.setConfig("max_steps", "12"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set("12"); err != nil || agent.maxSteps() != 12 {
+		t.Errorf("set_config max_steps 12 after /set: maxSteps() = %d, err = %v", agent.maxSteps(), err)
+	}
+
+	// An operator who lowers the limit lowers the model's choice with it
+	agent.MaxSteps = 4
+	if got := agent.maxSteps(); got != 4 {
+		t.Errorf("maxSteps() = %d after MaxSteps was lowered to 4", got)
+	}
+}
+
+func TestResetOptionsKeepsEnvDefaults(t *testing.T) {
+	for key := range modelOptions {
+		t.Setenv("OLLAMA_"+strings.ToUpper(key), "")
+	}
+	t.Setenv("OLLAMA_TEMPERATURE", "0.2")
+	t.Setenv("OLLAMA_NUM_CTX", "8192")
+	var out strings.Builder
+	agent := &Agent{Out: &out, Options: modelOptionsFromEnv()}
+	for _, line := range []string{"/set temperature 1.5", "/set top_k 5", "/reset-options"} {
+		agent.handleCommand(line)
+	}
+	want := map[string]any{"temperature": 0.2, "num_ctx": 8192}
+	if len(agent.Options) != len(want) {
+		t.Fatalf("options after /reset-options = %v, want %v", agent.Options, want)
+	}
+	for k, v := range want {
+		if agent.Options[k] != v {
+			t.Errorf("option %s = %v (%T), want %v", k, agent.Options[k], agent.Options[k], v)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxCSVFileSize = 10 << 20 // 10MB
+	maxCSVRows     = 500
+	maxCSVOutput   = 256 << 10 // 256KB
+)
+
+// csvCondition is a parsed where clause: column op value.
+type csvCondition struct {
+	column int
+	op     string
+	value  string
+}
+
+// csvOperators are tried longest first so ">=" isn't read as ">".
+var csvOperators = []string{" contains ", "==", "!=", ">=", "<=", "=", "<", ">"}
+
+// parseCSVCondition parses a where clause such as "age >= 30",
+// "city = Berlin" or "name contains ann" against the header row.
+func parseCSVCondition(where string, header []string) (*csvCondition, error) {
+	pos, op := -1, ""
+	for _, candidate := range csvOperators {
+		i := strings.Index(where, candidate)
+		if i >= 0 && (pos < 0 || i < pos) {
+			pos, op = i, candidate
+		}
+	}
+	if pos < 0 {
+		return nil, fmt.Errorf("invalid where %q: expected column, operator (=, !=, <, <=, >, >=, contains) and value", where)
+	}
+	name := strings.TrimSpace(where[:pos])
+	value := strings.Trim(strings.TrimSpace(where[pos+len(op):]), `"'`)
+	column, err := csvColumn(header, name)
+	if err != nil {
+		return nil, err
+	}
+	op = strings.TrimSpace(op)
+	if op == "==" {
+		op = "="
+	}
+	return &csvCondition{column: column, op: op, value: value}, nil
+}
+
+// match compares numerically when both sides are numbers and as strings
+// otherwise; contains is case-insensitive.
+func (c *csvCondition) match(row []string) bool {
+	cell := ""
+	if c.column < len(row) {
+		cell = strings.TrimSpace(row[c.column])
+	}
+	if c.op == "contains" {
+		return strings.Contains(strings.ToLower(cell), strings.ToLower(c.value))
+	}
+	cmp := strings.Compare(cell, c.value)
+	a, errA := strconv.ParseFloat(cell, 64)
+	b, errB := strconv.ParseFloat(c.value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // ">="
+		return cmp >= 0
+	}
+}
+
+func csvColumn(header []string, name string) (int, error) {
+	for i, h := range header {
+		if strings.TrimSpace(h) == name {
+			return i, nil
+		}
+	}
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown column %q (columns: %s)", name, strings.Join(header, ", "))
+}
+
+// csvQuery reads the CSV file at path (the first row is the header), keeps
+// the rows matching where, projects the selected columns and returns them
+// as JSON objects or, with format "csv", as CSV.
+func csvQuery(path string, selectCols []string, where, format string) (string, error) {
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		return "", fmt.Errorf("invalid format %q: use json or csv", format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat: %w", err)
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("path is a directory")
+	}
+	if fi.Size() > maxCSVFileSize {
+		return "", fmt.Errorf("file too large: %d bytes (limit %d)", fi.Size(), maxCSVFileSize)
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("empty CSV file")
+	}
+	if err != nil {
+		return "", fmt.Errorf("malformed CSV: %w", err)
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	columns := make([]int, 0, len(header))
+	if len(selectCols) == 0 {
+		for i := range header {
+			columns = append(columns, i)
+		}
+	} else {
+		for _, name := range selectCols {
+			i, err := csvColumn(header, name)
+			if err != nil {
+				return "", err
+			}
+			columns = append(columns, i)
+		}
+	}
+	var cond *csvCondition
+	if where = strings.TrimSpace(where); where != "" {
+		if cond, err = parseCSVCondition(where, header); err != nil {
+			return "", err
+		}
+	}
+
+	var rows [][]string
+	matched := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("malformed CSV: %w", err)
+		}
+		if cond != nil && !cond.match(record) {
+			continue
+		}
+		matched++
+		if len(rows) >= maxCSVRows {
+			continue
+		}
+		row := make([]string, len(columns))
+		for j, c := range columns {
+			if c < len(record) {
+				row[j] = record[c]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	names := make([]string, len(columns))
+	for j, c := range columns {
+		names[j] = header[c]
+	}
+	var out []byte
+	if format == "csv" {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(names)
+		w.WriteAll(rows)
+		if err := w.Error(); err != nil {
+			return "", fmt.Errorf("write CSV: %w", err)
+		}
+		out = buf.Bytes()
+	} else {
+		objects := make([]map[string]string, len(rows))
+		for i, row := range rows {
+			objects[i] = make(map[string]string, len(names))
+			for j, name := range names {
+				objects[i][name] = row[j]
+			}
+		}
+		out, err = json.MarshalIndent(map[string]any{
+			"columns": names,
+			"matched": matched,
+			"rows":    objects,
+		}, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("marshal rows: %w", err)
+		}
+	}
+
+	result := string(out)
+	if len(result) > maxCSVOutput {
+		result = result[:maxCSVOutput] + "\n... output truncated; narrow the query with select or where ..."
+	} else if matched > len(rows) {
+		result += fmt.Sprintf("\n... only the first %d of %d matching rows are shown ...", len(rows), matched)
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// queryPeople runs csv_query on testdata/csv/people.csv, which starts with
+// a byte order mark, quotes a cell containing a comma and ends on a short row.
+func queryPeople(t *testing.T, args map[string]any) (string, error) {
+	t.Helper()
+	tool, ok := DefaultRegistry.Lookup("csv_query")
+	if !ok {
+		t.Fatal("csv_query is not registered")
+	}
+	args["path"] = "people.csv"
+	return tool.Execute(withProjectRoot(context.Background(), "testdata/csv"), args)
+}
+
+func TestCSVQueryJSON(t *testing.T) {
+	tests := []struct {
+		where string
+		names []string
+	}{
+		{"", []string{"Ann", "Bob", "Carla", "Dan", "Eve"}},
+		// Numbers compare numerically: 9 < 30 although "9" > "30";
+		// empty cells compare as strings and sort first
+		{"age < 30", []string{"Bob", "Dan", "Eve"}},
+		{"age >= 34", []string{"Ann", "Carla"}},
+		{"age == 9", []string{"Bob"}},
+		{"city = Berlin", []string{"Ann"}},
+		{"city != Berlin", []string{"Bob", "Carla", "Dan", "Eve"}},
+		{"city contains BERLIN", []string{"Ann", "Carla"}},
+		{`city = "Paris, France"`, []string{"Bob"}},
+		{"City = Oslo", []string{"Dan"}},
+		{"city = ", []string{"Eve"}},
+	}
+	for _, tt := range tests {
+		out, err := queryPeople(t, map[string]any{"where": tt.where, "select": []any{"name"}})
+		if err != nil {
+			t.Fatalf("where %q: %v", tt.where, err)
+		}
+		var got struct {
+			Columns []string            `json:"columns"`
+			Matched int                 `json:"matched"`
+			Rows    []map[string]string `json:"rows"`
+		}
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("where %q: %v\n%s", tt.where, err, out)
+		}
+		var names []string
+		for _, row := range got.Rows {
+			names = append(names, row["name"])
+		}
+		if strings.Join(names, ",") != strings.Join(tt.names, ",") || got.Matched != len(tt.names) {
+			t.Errorf("where %q = %v (matched %d), want %v", tt.where, names, got.Matched, tt.names)
+		}
+		if len(got.Columns) != 1 || got.Columns[0] != "name" {
+			t.Errorf("where %q: columns = %v, want [name]", tt.where, got.Columns)
+		}
+	}
+}
+
+func TestCSVQueryCSV(t *testing.T) {
+	out, err := queryPeople(t, map[string]any{"select": []any{"city", "name"}, "where": "age > 30", "format": "csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "city,name\nBerlin,Ann\nberlin,Carla\n"
+	if out != want {
+		t.Errorf("csv output = %q, want %q", out, want)
+	}
+
+	out, err = queryPeople(t, map[string]any{"where": "name = Bob", "format": "csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "name,age,city\nBob,9,\"Paris, France\"\n"; out != want {
+		t.Errorf("csv output = %q, want %q", out, want)
+	}
+}
+
+func TestCSVQueryErrors(t *testing.T) {
+	tests := []struct {
+		args map[string]any
+		want string
+	}{
+		{map[string]any{"select": "country"}, `unknown column "country"`},
+		{map[string]any{"where": "country = NL"}, `unknown column "country"`},
+		{map[string]any{"where": "age"}, "invalid where"},
+		{map[string]any{"format": "xml"}, "invalid format"},
+	}
+	for _, tt := range tests {
+		_, err := queryPeople(t, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("csv_query %v: error %v, want %q", tt.args, err, tt.want)
+		}
+	}
+
+	tool, _ := DefaultRegistry.Lookup("csv_query")
+	_, err := tool.Execute(withProjectRoot(context.Background(), "testdata/csv"), map[string]any{"path": "../encoding/utf8.txt"})
+	if err == nil {
+		t.Error("csv_query outside the project root succeeded")
+	}
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// debugEnabled turns on verbose diagnostics, set via KUTAGENT_DEBUG.
+var debugEnabled = os.Getenv("KUTAGENT_DEBUG") != ""
+
+// debugOut receives the diagnostics.
+var debugOut io.Writer = os.Stderr
+
+// debugf writes a diagnostic line to debugOut when debugging is enabled.
+func debugf(format string, args ...any) {
+	if !debugEnabled {
+		return
+	}
+	fmt.Fprintf(debugOut, "debug: "+format+"\n", args...)
+}
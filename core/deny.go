@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"strings"
+)
+
+// DenyReason identifies why an action was blocked.
+type DenyReason string
+
+const (
+	// DenyDisabled: the tool is switched off for this deployment or session.
+	DenyDisabled DenyReason = "disabled"
+	// DenyOutsideRoot: the tool was pointed at a path outside the project.
+	DenyOutsideRoot DenyReason = "outside_root"
+	// DenyProcessLimit: too many background processes are running.
+	DenyProcessLimit DenyReason = "process_limit"
+	// DenySetting: set_config was asked to change a protected setting.
+	DenySetting DenyReason = "setting"
+	// DenyUser: the user declined the call when asked for approval.
+	DenyUser DenyReason = "user_denied"
+)
+
+// DefaultDenyMessages are returned to the model when an action is blocked.
+// {tool} is replaced by the tool name and {detail} by the specific reason.
+// They say why the call was blocked and what to do instead, so the model
+// adapts rather than retrying the same call.
+var DefaultDenyMessages = map[DenyReason]string{
+	DenyDisabled:     "action blocked: {tool} is disabled by configuration; do not call it again, use another tool or answer without it",
+	DenyOutsideRoot:  "action blocked: {tool} can only access paths inside the project root; use a path relative to the project",
+	DenyProcessLimit: "action blocked: {tool} refused because {detail}; stop a process you no longer need with stop_process, or wait for one to finish",
+	DenySetting:      "action blocked: {detail}; this setting is controlled by the operator, continue with the current value",
+	DenyUser:         "action blocked: the user denied execution of {tool}; do not retry the same call, ask the user how to proceed or try another approach",
+}
+
+var (
+	errProcessLimit  = errors.New("too many running processes")
+	errSettingLocked = errors.New("locked")
+	// errToolNotAllowed: the tool is left out of AllowedDestructiveTools
+	errToolNotAllowed = errors.New("tool not allowed")
+)
+
+// denyReasonOf maps the sentinel errors of blocked actions to their reason.
+func denyReasonOf(err error) (DenyReason, bool) {
+	switch {
+	case errors.Is(err, errOutsideRoot):
+		return DenyOutsideRoot, true
+	case errors.Is(err, errProcessLimit):
+		return DenyProcessLimit, true
+	case errors.Is(err, errSettingLocked):
+		return DenySetting, true
+	case errors.Is(err, errToolNotAllowed):
+		return DenyDisabled, true
+	}
+	return "", false
+}
+
+// denyError builds the message for a blocked call to tool, from
+// DenyMessages if it has one for reason, else from DefaultDenyMessages.
+func (agent *Agent) denyError(reason DenyReason, tool string, cause error) error {
+	tmpl, ok := agent.DenyMessages[reason]
+	if !ok {
+		tmpl = DefaultDenyMessages[reason]
+	}
+	detail := ""
+	if cause != nil {
+		detail = cause.Error()
+	}
+	msg := strings.NewReplacer("{tool}", tool, "{detail}", detail).Replace(tmpl)
+	return &deniedError{msg: msg, cause: cause}
+}
+
+// deniedError carries the message for the model while still matching its
+// cause with errors.Is.
+type deniedError struct {
+	msg   string
+	cause error
+}
+
+func (e *deniedError) Error() string { return e.msg }
+func (e *deniedError) Unwrap() error { return e.cause }
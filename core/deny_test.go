@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDenyPaths(t *testing.T) {
+	root, _ := newRoot(t)
+	fillProcesses := func(t *testing.T, agent *Agent) {
+		agent.processes = newProcessTable()
+		t.Cleanup(agent.StopProcesses)
+		for i := 0; i < maxProcesses; i++ {
+			if _, err := agent.processes.start(root, "sleep 60"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	blockDestructive := func(t *testing.T, _ *Agent) {
+		saved := AllowedDestructiveTools
+		AllowedDestructiveTools = map[string]bool{}
+		t.Cleanup(func() { AllowedDestructiveTools = saved })
+	}
+	tests := []struct {
+		name  string
+		agent Agent
+		setup func(t *testing.T, agent *Agent)
+		call  *ToolCall
+		want  string
+	}{
+		{
+			name:  "disabled",
+			agent: Agent{DisabledTools: map[string]bool{"run_shell": true}},
+			call:  call("run_shell", map[string]any{"command": "true"}),
+			want:  "action blocked: run_shell is disabled by configuration; do not call it again, use another tool or answer without it",
+		},
+		{
+			name:  "not in allowlist",
+			agent: Agent{EnabledTools: map[string]bool{"read_file": true}},
+			call:  call("list_files", map[string]any{"path": "."}),
+			want:  "action blocked: list_files is disabled by configuration; do not call it again, use another tool or answer without it",
+		},
+		{
+			name:  "destructive tool not allowed",
+			setup: blockDestructive,
+			call:  call("delete_file", map[string]any{"path": "x"}),
+			want:  "action blocked: delete_file is disabled by configuration; do not call it again, use another tool or answer without it",
+		},
+		{
+			name:  "user denied",
+			agent: Agent{Approve: func(string, map[string]any) bool { return false }},
+			call:  call("run_shell", map[string]any{"command": "true"}),
+			want:  "action blocked: the user denied execution of run_shell; do not retry the same call, ask the user how to proceed or try another approach",
+		},
+		{
+			name: "outside root",
+			call: call("read_file", map[string]any{"path": "../outside/secret"}),
+			want: "action blocked: read_file can only access paths inside the project root; use a path relative to the project",
+		},
+		{
+			name:  "process limit",
+			setup: fillProcesses,
+			call:  call("start_process", map[string]any{"command": "sleep 60"}),
+			want:  fmt.Sprintf("action blocked: start_process refused because too many running processes (limit %d); stop a process you no longer need with stop_process, or wait for one to finish", maxProcesses),
+		},
+		{
+			name:  "locked setting",
+			agent: Agent{ConfigTools: true},
+			call:  call("set_config", map[string]any{"key": "num_ctx", "value": "1"}),
+			want:  `action blocked: setting "num_ctx" can't be changed at runtime (locked); this setting is controlled by the operator, continue with the current value`,
+		},
+		{
+			name: "custom message",
+			agent: Agent{
+				DisabledTools: map[string]bool{"run_shell": true},
+				DenyMessages:  map[DenyReason]string{DenyDisabled: "no {tool} here"},
+			},
+			call: call("run_shell", map[string]any{"command": "true"}),
+			want: "no run_shell here",
+		},
+	}
+	seen := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := tt.agent
+			if tt.setup != nil {
+				tt.setup(t, &agent)
+			}
+			agent.session, agent.root = &Session{}, root
+			_, err := agent.runTool(context.Background(), tt.call, false)
+			if err == nil {
+				t.Fatal("call was not blocked")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("message = %q, want %q", err.Error(), tt.want)
+			}
+			var denied *deniedError
+			if !errors.As(err, &denied) {
+				t.Errorf("error %T is not a deniedError", err)
+			}
+			if other, ok := seen[err.Error()]; ok {
+				t.Errorf("same message as %q", other)
+			}
+			seen[err.Error()] = tt.name
+		})
+	}
+}
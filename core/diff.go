@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the LCS table lineDiff will build.
+const maxDiffCells = 4_000_000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// lineDiff computes a line-level edit script from a to b. It reports false
+// when the inputs are too large to diff.
+func lineDiff(a, b []string) ([]diffOp, bool) {
+	// Strip the common prefix and suffix; only the middle needs the LCS table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if (len(am)+1)*(len(bm)+1) > maxDiffCells {
+		return nil, false
+	}
+	// lcs[i][j] is the LCS length of am[i:] and bm[j:]
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	i, j := 0, 0
+	for i < len(am) && j < len(bm) {
+		switch {
+		case am[i] == bm[j]:
+			ops = append(ops, diffOp{' ', am[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', am[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', bm[j]})
+			j++
+		}
+	}
+	for ; i < len(am); i++ {
+		ops = append(ops, diffOp{'-', am[i]})
+	}
+	for ; j < len(bm); j++ {
+		ops = append(ops, diffOp{'+', bm[j]})
+	}
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops, true
+}
+
+// unifiedDiff renders the difference between two texts in unified diff
+// format with three lines of context. It returns "" when they are equal.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	ops, ok := lineDiff(splitLines(oldText), splitLines(newText))
+	if !ok {
+		return fmt.Sprintf("--- %s\n+++ %s\n(files differ; too large to diff)\n", oldName, newName)
+	}
+	const context = 3
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk until there are more than 2*context unchanged lines
+		hunkStart := max(start-context, 0)
+		end, unchanged := start, 0
+		for end < len(ops) && unchanged <= 2*context {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		hunkEnd := min(end-unchanged+context, len(ops))
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		// An empty range is numbered after the line it follows
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			b.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
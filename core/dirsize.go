@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const maxDirSizeNodes = 100000
+
+type sizeTotal struct {
+	bytes int64
+	files int
+}
+
+// dirSize adds up the size of the regular files under dir. With breakdown it
+// also reports the size of each immediate child of dir, largest first.
+func dirSize(root, dir string, breakdown, useGitignore bool) (string, error) {
+	gi := &gitignore{}
+	if useGitignore {
+		gi = loadGitignore(root)
+	}
+	var total sizeTotal
+	children := map[string]*sizeTotal{}
+	nodes, truncated := 0, false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		nodes++
+		if nodes > maxDirSizeNodes {
+			truncated = true
+			return filepath.SkipAll
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" && useGitignore || gi.Match(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		total.bytes += info.Size()
+		total.files++
+		if breakdown {
+			child, _, _ := strings.Cut(path[len(dir)+1:], string(os.PathSeparator))
+			if children[child] == nil {
+				children[child] = &sizeTotal{}
+			}
+			children[child].bytes += info.Size()
+			children[child].files++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("walk dir: %w", err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "total: %s in %d files\n", humanBytes(total.bytes), total.files)
+	if breakdown {
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return children[names[i]].bytes > children[names[j]].bytes })
+		for _, name := range names {
+			c := children[name]
+			fmt.Fprintf(&b, "%10s  %6d files  %s\n", humanBytes(c.bytes), c.files, name)
+		}
+	}
+	if truncated {
+		fmt.Fprintf(&b, "... stopped after %d entries; totals are incomplete ...\n", maxDirSizeNodes)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,336 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a half-written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("chmod temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+// writeFile creates or overwrites the file at path with content, creating
+// parent directories as needed. An existing file keeps its permissions.
+// Content over maxSize bytes is refused, matching what read_file returns.
+func writeFile(path, content string, maxSize int) (string, error) {
+	if len(content) > maxSize {
+		return "", fmt.Errorf("content too large: %d bytes (limit %d)", len(content), maxSize)
+	}
+	perm := os.FileMode(0o644)
+	verb := "created"
+	if fi, err := os.Stat(path); err == nil {
+		if fi.IsDir() {
+			return "", fmt.Errorf("path is a directory, not a file")
+		}
+		perm = fi.Mode().Perm()
+		verb = "overwrote"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("create parent directories: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(content), perm); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s (%d bytes written)", verb, filepath.Base(path), len(content)), nil
+}
+
+// appendFile adds content to the end of the file at path, creating it and
+// any missing parent directories first, and reports the new size. Content
+// over maxSize bytes is refused.
+func appendFile(path, content string, maxSize int) (string, error) {
+	if len(content) > maxSize {
+		return "", fmt.Errorf("content too large: %d bytes (limit %d)", len(content), maxSize)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return "", fmt.Errorf("path is a directory, not a file")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("create parent directories: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return "", fmt.Errorf("append: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("close file: %w", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+	return fmt.Sprintf("appended %d bytes to %s (now %d bytes)", len(content), filepath.Base(path), fi.Size()), nil
+}
+
+// replaceLines replaces the 1-based inclusive line range [start, end] of
+// the file at path with content. Files over maxSize bytes, before or after
+// the change, are refused.
+func replaceLines(path string, start, end int, content string, maxSize int) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("path is a directory, not a file")
+	}
+	if fi.Size() > int64(maxSize) {
+		return "", fmt.Errorf("file too large: %d bytes (limit %d)", fi.Size(), maxSize)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	text := string(b)
+	trailingNewline := strings.HasSuffix(text, "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if text == "" {
+		lines = nil
+	}
+	if start < 1 || end < start {
+		return "", fmt.Errorf("invalid line range %d-%d", start, end)
+	}
+	if end > len(lines) {
+		return "", fmt.Errorf("line range %d-%d is past the end of the file (%d lines)", start, end, len(lines))
+	}
+	var replacement []string
+	if content != "" {
+		replacement = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	out := append([]string{}, lines[:start-1]...)
+	out = append(out, replacement...)
+	out = append(out, lines[end:]...)
+	result := strings.Join(out, "\n")
+	if trailingNewline && len(out) > 0 {
+		result += "\n"
+	}
+	if len(result) > maxSize {
+		return "", fmt.Errorf("content too large: the file would be %d bytes (limit %d)", len(result), maxSize)
+	}
+	if err := writeFileAtomic(path, []byte(result), fi.Mode().Perm()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("replaced lines %d-%d with %d lines; file now has %d lines", start, end, len(replacement), len(out)), nil
+}
+
+var errOutsideRoot = errors.New("access outside project root is not allowed")
+
+// resolveWithinRoot joins userPath onto root and makes sure the result stays
+// inside root, both lexically and after resolving symlinks, so a link in the
+// project can't be used to reach files outside it. The path doesn't have to
+// exist yet. It returns the path with every symlink below root resolved, so
+// opening it can't follow a link somewhere else.
+func resolveWithinRoot(root, userPath string) (string, error) {
+	joined := filepath.Join(root, filepath.Clean(userPath))
+	if !withinDir(root, joined) {
+		return "", errOutsideRoot
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("resolve project root: %w", err)
+	}
+	real, err := evalExistingSymlinks(joined)
+	if err != nil {
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	if !withinDir(realRoot, real) {
+		return "", errOutsideRoot
+	}
+	// Keep root as given, e.g. for paths reported relative to it
+	rel, err := filepath.Rel(realRoot, real)
+	if err != nil {
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// withinDir reports whether path is dir itself or lies below it.
+func withinDir(dir, path string) bool {
+	prefix := dir
+	// A filesystem root such as "/" already ends in a separator
+	if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix += string(os.PathSeparator)
+	}
+	return path == dir || strings.HasPrefix(path, prefix)
+}
+
+// maxSymlinkHops bounds how many dangling links evalExistingSymlinks
+// follows, so a loop of links can't keep it busy.
+const maxSymlinkHops = 40
+
+// evalExistingSymlinks resolves symlinks in the longest existing prefix of
+// path and appends the components that don't exist yet. A dangling symlink
+// counts as existing: its target is followed, so a link pointing outside
+// the root can't pass as a new file inside it.
+func evalExistingSymlinks(path string) (string, error) {
+	var rest []string
+	hops := 0
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if fi, lerr := os.Lstat(path); lerr == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			if hops++; hops > maxSymlinkHops {
+				return "", fmt.Errorf("too many levels of symbolic links: %s", path)
+			}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			path = filepath.Clean(target)
+			continue
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// parseFileMode parses an octal permission string such as "755" or "0644".
+// Only the permission bits are accepted; setuid, setgid and sticky are not.
+func parseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q: want an octal permission between 000 and 777", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// chmodWithinRoot sets the permissions of a file or directory inside root
+// and reports the change.
+func chmodWithinRoot(root, path, mode string) (string, error) {
+	perm, err := parseFileMode(mode)
+	if err != nil {
+		return "", err
+	}
+	abs, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(abs, perm); err != nil {
+		return "", fmt.Errorf("chmod: %w", err)
+	}
+	return fmt.Sprintf("%s: %s -> %s", path, info.Mode().Perm(), perm), nil
+}
+
+// deleteWithinRoot removes the file at path, or the directory with
+// everything in it when recursive is set. The project root itself is never
+// removed, and a missing path is an error.
+func deleteWithinRoot(root, path string, recursive bool) (string, error) {
+	target, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("resolve project root: %w", err)
+	}
+	if real, err := filepath.EvalSymlinks(target); err == nil && real == realRoot {
+		return "", fmt.Errorf("refusing to delete the project root")
+	}
+	// A symlink is deleted itself, not what it points to, so resolve only
+	// the directory it is in
+	lexical := filepath.Join(root, filepath.Clean(path))
+	dir, err := filepath.Rel(root, filepath.Dir(lexical))
+	if err != nil {
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	parent, err := resolveWithinRoot(root, dir)
+	if err != nil {
+		return "", err
+	}
+	abs := filepath.Join(parent, filepath.Base(lexical))
+	info, err := os.Lstat(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%s does not exist; nothing was deleted", path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("stat path: %w", err)
+	}
+	if !info.IsDir() {
+		if err := os.Remove(abs); err != nil {
+			return "", fmt.Errorf("delete: %w", err)
+		}
+		return fmt.Sprintf("deleted %s", path), nil
+	}
+	if !recursive {
+		return "", fmt.Errorf("%s is a directory; pass recursive: true to delete it and everything in it", path)
+	}
+	files := 0
+	filepath.WalkDir(abs, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files++
+		}
+		return nil
+	})
+	if err := os.RemoveAll(abs); err != nil {
+		return "", fmt.Errorf("delete: %w", err)
+	}
+	return fmt.Sprintf("deleted directory %s (%d files)", path, files), nil
+}
+
+// maxInvalidRuneRatio is the share of invalid UTF-8 sequences above which
+// content is treated as binary or in another encoding.
+const maxInvalidRuneRatio = 0.05
+
+// looksBinary reports whether data is unlikely to be readable text: it has a
+// NUL byte or too many invalid UTF-8 sequences.
+func looksBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	runes, invalid := 0, 0
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		}
+		runes++
+		data = data[size:]
+	}
+	return runes > 0 && float64(invalid)/float64(runes) > maxInvalidRuneRatio
+}
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newRoot returns a project root with symlinks resolved, next to a
+// directory outside it.
+func newRoot(t *testing.T) (root, outside string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+}
+
+func TestResolveWithinRoot(t *testing.T) {
+	root, outside := newRoot(t)
+	symlink(t, outside, filepath.Join(root, "out"))
+	symlink(t, filepath.Join(outside, "missing"), filepath.Join(root, "dangling"))
+	symlink(t, "dangling", filepath.Join(root, "dangling2"))
+	symlink(t, "sub", filepath.Join(root, "in"))
+	symlink(t, "sub/new.txt", filepath.Join(root, "dangling-in"))
+
+	tests := []struct {
+		path string
+		want string // relative to root; "" means errOutsideRoot
+	}{
+		{"a.txt", "a.txt"},
+		{"sub/../a.txt", "a.txt"},
+		{"new/dir/file", "new/dir/file"},
+		{"..", ""},
+		{"../outside/x", ""},
+		{"sub/../../outside", ""},
+		// Absolute paths are taken relative to the root
+		{outside, outside[1:]},
+		{"out", ""},
+		{"out/x", ""},
+		{"dangling", ""},
+		{"dangling2", ""},
+		{"dangling/x", ""},
+		{"in/file", "sub/file"},
+		{"dangling-in", "sub/new.txt"},
+	}
+	for _, tt := range tests {
+		got, err := resolveWithinRoot(root, tt.path)
+		if tt.want == "" {
+			if !errors.Is(err, errOutsideRoot) {
+				t.Errorf("resolveWithinRoot(%q) = %q, %v; want errOutsideRoot", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveWithinRoot(%q): %v", tt.path, err)
+			continue
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("resolveWithinRoot(%q) = %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestResolveWithinRootSymlinkLoop(t *testing.T) {
+	root, _ := newRoot(t)
+	symlink(t, "b", filepath.Join(root, "a"))
+	symlink(t, "a", filepath.Join(root, "b"))
+	if _, err := resolveWithinRoot(root, "a"); err == nil {
+		t.Fatal("resolved a symlink loop")
+	}
+}
+
+func TestWriteToolsDontFollowDanglingLinks(t *testing.T) {
+	root, outside := newRoot(t)
+	symlink(t, filepath.Join(outside, "pwned"), filepath.Join(root, "link"))
+	ctx := withProjectRoot(context.Background(), root)
+	for _, name := range []string{"append_file", "edit_file"} {
+		tool, _ := DefaultRegistry.Lookup(name)
+		_, err := tool.Execute(ctx, map[string]any{"path": "link", "content": "x"})
+		if !errors.Is(err, errOutsideRoot) {
+			t.Errorf("%s through a dangling link: err = %v, want errOutsideRoot", name, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file outside the root was created: %v", err)
+	}
+}
+
+func TestDeleteRemovesLinkNotTarget(t *testing.T) {
+	root, _ := newRoot(t)
+	if err := os.WriteFile(filepath.Join(root, "sub", "keep"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, "sub", filepath.Join(root, "link"))
+	if _, err := deleteWithinRoot(root, "link", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "link")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("link still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "sub", "keep")); err != nil {
+		t.Errorf("link target was deleted: %v", err)
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	sep := string(os.PathSeparator)
+	tests := []struct {
+		dir, path string
+		want      bool
+	}{
+		{sep + "a", sep + "a", true},
+		{sep + "a", sep + "a" + sep + "b", true},
+		{sep + "a", sep + "ab", false},
+		{sep + "a", sep, false},
+		{sep, sep, true},
+		{sep, sep + "etc", true},
+	}
+	for _, tt := range tests {
+		if got := withinDir(tt.dir, tt.path); got != tt.want {
+			t.Errorf("withinDir(%q, %q) = %v, want %v", tt.dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want os.FileMode
+		ok   bool
+	}{
+		{"755", 0o755, true},
+		{"0644", 0o644, true},
+		{"0o600", 0o600, true},
+		{"000", 0, true},
+		{"777", 0o777, true},
+		{"", 0, false},
+		{"0o", 0, false},
+		{"1777", 0, false},
+		{"888", 0, false},
+		{"-755", 0, false},
+		{"u+x", 0, false},
+		{"rwxr-xr-x", 0, false},
+		{"0x1ff", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseFileMode(tt.in)
+		if tt.ok != (err == nil) || got != tt.want {
+			t.Errorf("parseFileMode(%q) = %v, %v; want %v, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestChmodWithinRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows only has a read-only bit")
+	}
+	root, outside := newRoot(t)
+	file := filepath.Join(root, "sub", "run.sh")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := chmodWithinRoot(root, "sub/run.sh", "755")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sub/run.sh: -rw-r--r-- -> -rwxr-xr-x"; got != want {
+		t.Errorf("chmodWithinRoot() = %q, want %q", got, want)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("mode after chmod = %v, %v; want 0755", info.Mode().Perm(), err)
+	}
+	if _, err := chmodWithinRoot(root, "sub", "0o700"); err != nil {
+		t.Errorf("chmod of a directory: %v", err)
+	}
+
+	if _, err := chmodWithinRoot(root, "sub/run.sh", "u+x"); err == nil || !strings.Contains(err.Error(), "invalid mode") {
+		t.Errorf("chmod with a symbolic mode: err = %v, want invalid mode", err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0o755 {
+		t.Errorf("a rejected mode changed the file to %v", info.Mode().Perm())
+	}
+	if _, err := chmodWithinRoot(root, "missing", "644"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("chmod of a missing file: err = %v, want ErrNotExist", err)
+	}
+	if _, err := chmodWithinRoot(root, "../outside", "777"); !errors.Is(err, errOutsideRoot) {
+		t.Errorf("chmod outside the root: err = %v, want errOutsideRoot", err)
+	}
+	if info, _ := os.Stat(outside); info.Mode().Perm() == 0o777 {
+		t.Error("directory outside the root was changed")
+	}
+}
+
+func TestLooksBinaryFixtures(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"pixel.png", true},
+		{"latin1.txt", true},
+		{"utf8.txt", false},
+		// One invalid byte among many runes stays under maxInvalidRuneRatio
+		{"stray-byte.txt", false},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", "encoding", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := looksBinary(data); got != tt.want {
+			t.Errorf("looksBinary(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestLooksBinaryRatio(t *testing.T) {
+	// 100 runes of which n are invalid bytes
+	text := func(n int) []byte {
+		return append(bytes.Repeat([]byte{0xff}, n), strings.Repeat("a", 100-n)...)
+	}
+	limit := int(maxInvalidRuneRatio * 100)
+	if looksBinary(text(limit)) {
+		t.Errorf("%d%% invalid runes was treated as binary", limit)
+	}
+	if !looksBinary(text(limit + 1)) {
+		t.Errorf("%d%% invalid runes was treated as text", limit+1)
+	}
+	if looksBinary(nil) {
+		t.Error("empty content was treated as binary")
+	}
+}
+
+func TestReadFileReportsBinary(t *testing.T) {
+	root, _ := newRoot(t)
+	png, err := os.ReadFile(filepath.Join("testdata", "encoding", "pixel.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "pixel.png"), png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := withProjectRoot(context.Background(), root)
+	tool, _ := DefaultRegistry.Lookup("read_file")
+	got, err := tool.Execute(ctx, map[string]any{"path": "pixel.png"})
+	if err != nil || !strings.HasPrefix(got, "file appears to be binary") {
+		t.Errorf("read_file of a PNG = %q, %v", got, err)
+	}
+	got, err = tool.Execute(ctx, map[string]any{"path": "pixel.png", "encoding": "base64"})
+	if err != nil || got != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("read_file of a PNG as base64 = %q, %v", got, err)
+	}
+}
+
+func TestPathNormalize(t *testing.T) {
+	root, outside := newRoot(t)
+	if err := os.Mkdir(filepath.Join(root, "..foo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ path, want string }{
+		{"sub/../..foo", "path=..foo\ninside_root=true\nexists=true"},
+		{"..foo/bar", "path=..foo/bar\ninside_root=true\nexists=false"},
+		{"./sub//", "path=sub\ninside_root=true\nexists=true"},
+		{".", "path=.\ninside_root=true\nexists=true"},
+		{"..", "path=..\ninside_root=false"},
+		{"sub/../../outside", "path=../outside\ninside_root=false"},
+		{outside, "path=../outside\ninside_root=false"},
+		{filepath.Join(root, "sub"), "path=sub\ninside_root=true\nexists=true"},
+	}
+	tool, _ := DefaultRegistry.Lookup("path_normalize")
+	ctx := withProjectRoot(context.Background(), root)
+	for _, tt := range tests {
+		got, err := tool.Execute(ctx, map[string]any{"path": tt.path})
+		if err != nil || got != tt.want {
+			t.Errorf("path_normalize(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestReplaceLines(t *testing.T) {
+	root, _ := newRoot(t)
+	path := filepath.Join(root, "f.txt")
+	const original = "one\ntwo\nthree\n"
+	tests := []struct {
+		name       string
+		start, end int
+		content    string
+		want       string
+	}{
+		{"first line", 1, 1, "ONE", "ONE\ntwo\nthree\n"},
+		{"last line", 3, 3, "THREE\n", "one\ntwo\nTHREE\n"},
+		{"whole file", 1, 3, "only", "only\n"},
+		{"grow", 2, 2, "2a\n2b", "one\n2a\n2b\nthree\n"},
+		{"delete range", 1, 2, "", "three\n"},
+		{"delete everything", 1, 3, "", ""},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := replaceLines(path, tt.start, tt.end, tt.content, defaultLimit); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got, _ := os.ReadFile(path); string(got) != tt.want {
+			t.Errorf("%s: file = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	errs := []struct {
+		name       string
+		start, end int
+		content    string
+		maxSize    int
+		want       string
+	}{
+		{"end before start", 3, 2, "x", defaultLimit, "invalid line range 3-2"},
+		{"line zero", 0, 1, "x", defaultLimit, "invalid line range 0-1"},
+		{"past the end", 2, 4, "x", defaultLimit, "past the end of the file (3 lines)"},
+		{"file over the limit", 1, 1, "x", 10, "file too large: 14 bytes (limit 10)"},
+		{"result over the limit", 1, 1, strings.Repeat("x", 20), 20, "content too large"},
+	}
+	for _, tt := range errs {
+		if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := replaceLines(path, tt.start, tt.end, tt.content, tt.maxSize)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+		if got, _ := os.ReadFile(path); string(got) != original {
+			t.Errorf("%s: a refused change left %q", tt.name, got)
+		}
+	}
+}
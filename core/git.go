@@ -0,0 +1,61 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runGit runs git with args inside root and returns its combined output.
+func runGit(ctx context.Context, root string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = root
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return strings.TrimSpace(out.String()), err
+}
+
+// gitCommit stages either everything or the given paths and commits them,
+// returning the new commit hash.
+func gitCommit(ctx context.Context, root, message string, addAll bool, paths []string) (string, error) {
+	if addAll {
+		if out, err := runGit(ctx, root, "add", "-A"); err != nil {
+			return "", fmt.Errorf("git add: %v: %s", err, out)
+		}
+	} else if len(paths) > 0 {
+		for _, p := range paths {
+			if _, err := resolveWithinRoot(root, p); err != nil {
+				return "", fmt.Errorf("%s: %w", p, err)
+			}
+		}
+		addArgs := append([]string{"add", "--"}, paths...)
+		if out, err := runGit(ctx, root, addArgs...); err != nil {
+			return "", fmt.Errorf("git add: %v: %s", err, out)
+		}
+	}
+	// diff --cached --quiet exits 1 when something is staged
+	_, err := runGit(ctx, root, "diff", "--cached", "--quiet")
+	if err == nil {
+		return "", fmt.Errorf("nothing staged to commit")
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 1 {
+		return "", fmt.Errorf("git diff: %w", err)
+	}
+	if out, err := runGit(ctx, root, "commit", "-m", message); err != nil {
+		return "", fmt.Errorf("git commit: %v: %s", err, out)
+	}
+	hash, err := runGit(ctx, root, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse: %v: %s", err, hash)
+	}
+	return hash, nil
+}
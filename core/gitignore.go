@@ -0,0 +1,80 @@
+package core
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitignore is a small matcher for the patterns in a project's top-level
+// .gitignore. It understands comments, negation, anchored ("/foo") and
+// directory-only ("foo/") patterns, which covers what most projects use.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// loadGitignore reads root/.gitignore. A missing file yields an empty matcher.
+func loadGitignore(root string) *gitignore {
+	gi := &gitignore{}
+	f, err := os.Open(filepath.Join(root, ".gitignore"))
+	if err != nil {
+		return gi
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			r.anchored = true
+			line = line[1:]
+		} else if strings.Contains(line, "/") {
+			// A slash in the middle also anchors the pattern to the root
+			r.anchored = true
+		}
+		r.pattern = line
+		gi.rules = append(gi.rules, r)
+	}
+	return gi
+}
+
+// Match reports whether rel, a slash-separated path relative to the root,
+// is ignored. The last matching rule wins, as in git.
+func (gi *gitignore) Match(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range gi.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		var ok bool
+		if r.anchored {
+			ok, _ = path.Match(r.pattern, rel)
+		} else {
+			ok, _ = path.Match(r.pattern, path.Base(rel))
+		}
+		if ok {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
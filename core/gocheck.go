@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const compileCheckTimeout = 2 * time.Minute
+
+// CompileError is one diagnostic reported by the Go compiler.
+type CompileError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+var compileErrorRe = regexp.MustCompile(`^(\S+\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// compileCheck runs go build on the package at path (every package when
+// path is empty) inside root and returns "ok" or the compiler errors as
+// JSON. A trailing "/..." on path includes its subpackages.
+func compileCheck(ctx context.Context, root, path string) (string, error) {
+	pattern := "./..."
+	if path != "" {
+		recursive := strings.HasSuffix(path, "/...")
+		path = strings.TrimSuffix(path, "/...")
+		abs, err := resolveWithinRoot(root, path)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", path)
+		}
+		rel, _ := filepath.Rel(root, abs)
+		pattern = "./" + filepath.ToSlash(rel)
+		if recursive {
+			pattern += "/..."
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compileCheckTimeout)
+	defer cancel()
+	// Building to the null device type-checks and links without leaving
+	// binaries behind in the project.
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", os.DevNull, pattern)
+	cmd.Dir = root
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("go build timed out after %s", compileCheckTimeout)
+	}
+	if err == nil {
+		return "ok", nil
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return "", fmt.Errorf("run go build: %w", err)
+	}
+	diags := parseCompileErrors(out.String())
+	if len(diags) == 0 {
+		// Not a compiler error, e.g. a broken go.mod; pass it on verbatim
+		return "", fmt.Errorf("go build failed: %s", strings.TrimSpace(out.String()))
+	}
+	data, err := json.MarshalIndent(diags, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal errors: %w", err)
+	}
+	return string(data), nil
+}
+
+// parseCompileErrors extracts file:line[:col]: message diagnostics from go
+// build output. Indented lines continue the previous message; "# pkg"
+// headers are skipped.
+func parseCompileErrors(output string) []CompileError {
+	var diags []CompileError
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := compileErrorRe.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			diags = append(diags, CompileError{
+				File:    strings.TrimPrefix(m[1], "./"),
+				Line:    n,
+				Column:  col,
+				Message: m[4],
+			})
+			continue
+		}
+		if len(diags) > 0 && (strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ")) {
+			diags[len(diags)-1].Message += "\n" + strings.TrimSpace(line)
+		}
+	}
+	return diags
+}
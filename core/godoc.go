@@ -0,0 +1,57 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const goDocTimeout = 30 * time.Second
+
+// goDoc runs go doc for pkg, or pkg.symbol, inside root so packages of the
+// project's module and its dependencies resolve as they do when building.
+func goDoc(ctx context.Context, root, pkg, symbol string) (string, error) {
+	// Arguments starting with "-" would be taken as go doc flags
+	if strings.HasPrefix(pkg, "-") || strings.HasPrefix(symbol, "-") {
+		return "", fmt.Errorf("package and symbol must not start with '-'")
+	}
+	target := pkg
+	if symbol != "" {
+		target += "." + symbol
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, goDocTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "go", "doc", target)
+	cmd.Dir = root
+	// go doc may start go list; don't wait on it once go doc is killed
+	cmd.WaitDelay = 2 * time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("go doc timed out after %s", goDocTimeout)
+	}
+	if err != nil {
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			return "", fmt.Errorf("run go doc: %w", err)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("go doc %s: %s", target, msg)
+	}
+	out := stdout.String()
+	const maxBytes = 1 << 20 // 1MB
+	if len(out) > maxBytes {
+		out = out[:maxBytes] + "\n... [truncated] ..."
+	}
+	return out, nil
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"os"
+	"strconv"
+)
+
+func historyLimitFromEnv(key string) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return 0
+}
+
+// trimHistory drops the oldest messages so that what is sent stays within
+// MaxHistoryMessages and MaxHistoryTokens (estimated), whichever are set.
+// System messages are always kept, and an assistant message is dropped
+// together with the tool results that answer it, so no tool result is left
+// without its call. The latest user message and everything after it are
+// kept even if they alone are over the limits, so the model never loses the
+// task it is working on. The session's history itself is not changed.
+func (agent *Agent) trimHistory(msgs []UserMessage) []UserMessage {
+	if agent.MaxHistoryMessages <= 0 && agent.MaxHistoryTokens <= 0 {
+		return msgs
+	}
+	var system, rest []UserMessage
+	for _, m := range msgs {
+		if m.Role == "system" {
+			system = append(system, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	// It is safe to cut before any message except a tool result, which has
+	// to stay with the call before it, and no later than the last user
+	// message.
+	starts, usable := []int{0}, 1
+	for i, m := range rest {
+		if i > 0 && m.Role != "tool" {
+			starts = append(starts, i)
+		}
+		if m.Role == "user" {
+			usable = len(starts)
+		}
+	}
+	starts = starts[:usable]
+
+	baseTokens := estimateTokens(agent.SystemPrompt)
+	for _, m := range system {
+		baseTokens += estimateTokens(m.Content)
+	}
+	tokens := make([]int, len(rest)+1) // tokens[i] = estimate for rest[i:]
+	for i := len(rest) - 1; i >= 0; i-- {
+		tokens[i] = tokens[i+1] + estimateTokens(rest[i].Content)
+	}
+	fits := func(from int) bool {
+		if agent.MaxHistoryMessages > 0 && len(system)+len(rest)-from > agent.MaxHistoryMessages {
+			return false
+		}
+		if agent.MaxHistoryTokens > 0 && baseTokens+tokens[from] > agent.MaxHistoryTokens {
+			return false
+		}
+		return true
+	}
+	cut := starts[len(starts)-1]
+	for _, start := range starts {
+		if fits(start) {
+			cut = start
+			break
+		}
+	}
+	if cut == 0 {
+		return msgs
+	}
+	debugf("trimmed %d of %d messages from the request history", cut, len(msgs))
+	return append(system, rest[cut:]...)
+}
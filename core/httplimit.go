@@ -0,0 +1,76 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// defaultMaxHTTPConns is used unless KUTAGENT_MAX_HTTP_CONNS is set.
+const defaultMaxHTTPConns = 16
+
+// outboundTransport is used by every outbound HTTP request, to the provider
+// and from tools alike, so parallel tool calls can't open an unbounded
+// number of connections.
+var outboundTransport http.RoundTripper = newLimitedTransport(pooledTransport(maxHTTPConnsFromEnv()), maxHTTPConnsFromEnv())
+
+// toolHTTPClient is shared by the tools that fetch URLs so their connections
+// are pooled. It has no Timeout; the request context bounds each call.
+var toolHTTPClient = &http.Client{Transport: outboundTransport}
+
+// pooledTransport is http.DefaultTransport keeping up to idle connections
+// per host, rather than the default two, so a step loop hitting the same
+// endpoint reuses its connections instead of reopening them.
+func pooledTransport(idle int) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = idle
+	return t
+}
+
+func maxHTTPConnsFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("KUTAGENT_MAX_HTTP_CONNS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxHTTPConns
+}
+
+// limitedTransport allows at most cap(slots) requests in flight. A request
+// holds its slot until its response body is closed, since the connection is
+// in use until then.
+type limitedTransport struct {
+	base  http.RoundTripper
+	slots chan struct{}
+}
+
+func newLimitedTransport(base http.RoundTripper, limit int) *limitedTransport {
+	return &limitedTransport{base: base, slots: make(chan struct{}, limit)}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
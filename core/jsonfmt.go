@@ -0,0 +1,52 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxJSONArrayItems is how many elements of each array prettyJSON keeps.
+const maxJSONArrayItems = 50
+
+func isJSONContentType(ct string) bool {
+	ct = strings.ToLower(ct)
+	return strings.HasPrefix(ct, "application/json") || strings.Contains(ct, "+json")
+}
+
+// prettyJSON indents data, cutting arrays down to maxJSONArrayItems with a
+// note about what was left out. Invalid JSON is returned unchanged.
+func prettyJSON(data []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return string(data)
+	}
+	out, err := json.MarshalIndent(truncateJSONArrays(v), "", "  ")
+	if err != nil {
+		return string(data)
+	}
+	return string(out)
+}
+
+func truncateJSONArrays(v any) any {
+	switch t := v.(type) {
+	case []any:
+		n := len(t)
+		if n > maxJSONArrayItems {
+			t = append(t[:maxJSONArrayItems:maxJSONArrayItems], fmt.Sprintf("... %d more elements omitted ...", n-maxJSONArrayItems))
+		}
+		for i := range t {
+			t[i] = truncateJSONArrays(t[i])
+		}
+		return t
+	case map[string]any:
+		for k, e := range t {
+			t[k] = truncateJSONArrays(e)
+		}
+		return t
+	}
+	return v
+}
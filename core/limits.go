@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+// defaultLimit is the size the tools were always capped at.
+const defaultLimit = 1 << 20 // 1MB
+
+// Limits bounds how much the tools read or return, in bytes. A zero field
+// uses the 1MB default.
+type Limits struct {
+	// FileBytes is the largest file read_file returns and replace_lines
+	// edits, and the most edit_file and append_file write at once.
+	FileBytes int
+	// ListOutputBytes caps the output of list_files.
+	ListOutputBytes int
+	// ShellOutputBytes caps the output kept from a run_shell command.
+	ShellOutputBytes int
+	// FetchBytes caps the body fetch_url reads.
+	FetchBytes int
+}
+
+// DefaultLimits returns the limits the tools use unless told otherwise.
+func DefaultLimits() Limits {
+	return Limits{
+		FileBytes:        defaultLimit,
+		ListOutputBytes:  defaultLimit,
+		ShellOutputBytes: defaultLimit,
+		FetchBytes:       defaultLimit,
+	}
+}
+
+// withDefaults fills the unset fields of l from DefaultLimits.
+func (l Limits) withDefaults() Limits {
+	d := DefaultLimits()
+	if l.FileBytes <= 0 {
+		l.FileBytes = d.FileBytes
+	}
+	if l.ListOutputBytes <= 0 {
+		l.ListOutputBytes = d.ListOutputBytes
+	}
+	if l.ShellOutputBytes <= 0 {
+		l.ShellOutputBytes = d.ShellOutputBytes
+	}
+	if l.FetchBytes <= 0 {
+		l.FetchBytes = d.FetchBytes
+	}
+	return l
+}
+
+type limitsKey struct{}
+
+// withLimits makes l the limits for the tools run with ctx.
+func withLimits(ctx context.Context, l Limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, l)
+}
+
+// limitsFrom returns the limits set on ctx, with defaults for anything
+// unset.
+func limitsFrom(ctx context.Context) Limits {
+	l, _ := ctx.Value(limitsKey{}).(Limits)
+	return l.withDefaults()
+}
+
+// truncationNotice is appended to output cut off at limit bytes, so every
+// tool reports the limit it actually applied in the same words.
+func truncationNotice(limit int) string {
+	return fmt.Sprintf("\n... truncated at the %s (%d byte) limit ...", humanBytes(int64(limit)), limit)
+}
+
+// cutAtRune returns s cut to at most n bytes, backing up so a multi-byte
+// character isn't split.
+func cutAtRune(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
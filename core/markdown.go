@@ -0,0 +1,66 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdImage    = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	mdBold     = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdItalic   = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:[^*_]*?\S)?)[*_]([^\w*]|$)`)
+	mdCode     = regexp.MustCompile("`([^`]+)`")
+	mdHeading  = regexp.MustCompile(`^#{1,6}\s+`)
+	mdBullet   = regexp.MustCompile(`^(\s*)[*+-]\s+`)
+	mdRule     = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	mdQuote    = regexp.MustCompile(`^\s*>\s?`)
+	mdTrailing = regexp.MustCompile(`\s+#+\s*$`)
+)
+
+func isMarkdownContent(ct, rawURL string) bool {
+	ct = strings.ToLower(ct)
+	if strings.HasPrefix(ct, "text/markdown") || strings.HasPrefix(ct, "text/x-markdown") {
+		return true
+	}
+	path := strings.ToLower(rawURL)
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	return strings.HasSuffix(path, ".md") || strings.HasSuffix(path, ".markdown")
+}
+
+// markdownToText strips markdown syntax while keeping the document's line
+// structure. Links keep their targets in parentheses; fenced code is kept
+// verbatim.
+func markdownToText(src string) string {
+	var out []string
+	inFence := false
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+		if mdRule.MatchString(line) {
+			out = append(out, "")
+			continue
+		}
+		line = mdQuote.ReplaceAllString(line, "")
+		if mdHeading.MatchString(line) {
+			line = mdTrailing.ReplaceAllString(mdHeading.ReplaceAllString(line, ""), "")
+		}
+		line = mdBullet.ReplaceAllString(line, "${1}- ")
+		line = mdImage.ReplaceAllString(line, "$1 ($2)")
+		line = mdLink.ReplaceAllString(line, "$1 ($2)")
+		line = mdCode.ReplaceAllString(line, "$1")
+		line = mdBold.ReplaceAllString(line, "$2")
+		line = mdItalic.ReplaceAllString(line, "$1$2$3")
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToText(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"headings", "# Title\n\n## Usage ##\n###### Deep", "Title\n\nUsage\nDeep"},
+		{"not a heading", "#hashtag and C# code", "#hashtag and C# code"},
+		{
+			"code fence kept verbatim",
+			"Run:\n```sh\n# not a heading\n- not a list *or emphasis* [x](y)\n```\nafter",
+			"Run:\n# not a heading\n- not a list *or emphasis* [x](y)\nafter",
+		},
+		{"tilde fence", "~~~\n**raw**\n~~~", "**raw**"},
+		{"inline code", "call `read_file` with `path`", "call read_file with path"},
+		{"lists", "* one\n+ two\n- three\n  - nested\n1. numbered", "- one\n- two\n- three\n  - nested\n1. numbered"},
+		{"emphasis", "**bold**, __also bold__, *italic* and _italic_", "bold, also bold, italic and italic"},
+		{"snake_case untouched", "use max_depth and list_files", "use max_depth and list_files"},
+		{"links keep targets", "see [the docs](https://example.com/docs \"Docs\")", "see the docs (https://example.com/docs)"},
+		{"images", "![logo](logo.png)", "logo (logo.png)"},
+		{"quotes and rules", "> quoted\n\n---\n\nafter", "quoted\n\n\n\nafter"},
+		{"plain text passes through", "Just a sentence.\nAnd another.", "Just a sentence.\nAnd another."},
+	}
+	for _, tt := range tests {
+		if got := markdownToText(tt.src); got != tt.want {
+			t.Errorf("%s: markdownToText(%q) = %q, want %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestIsMarkdownContent(t *testing.T) {
+	tests := []struct {
+		ct, url string
+		want    bool
+	}{
+		{"text/markdown; charset=utf-8", "https://example.com/readme", true},
+		{"text/x-markdown", "https://example.com/", true},
+		{"text/plain", "https://example.com/README.md", true},
+		{"text/plain", "https://example.com/notes.markdown?raw=1#top", true},
+		{"text/plain", "https://example.com/readme.txt", false},
+		{"text/html", "https://example.com/md", false},
+	}
+	for _, tt := range tests {
+		if got := isMarkdownContent(tt.ct, tt.url); got != tt.want {
+			t.Errorf("isMarkdownContent(%q, %q) = %t, want %t", tt.ct, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestFetchURLRenderMarkdown(t *testing.T) {
+	const doc = "# Title\n\nSome **bold** text."
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown")
+		w.Write([]byte(doc))
+	}))
+	defer srv.Close()
+	tool, _ := DefaultRegistry.Lookup("fetch_url")
+	tests := []struct {
+		args map[string]any
+		want string
+	}{
+		{map[string]any{"url": srv.URL}, "Title\n\nSome bold text."},
+		{map[string]any{"url": srv.URL, "render_markdown": false}, doc},
+	}
+	for _, tt := range tests {
+		got, err := tool.Execute(context.Background(), tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, body, _ := strings.Cut(got, "\n")
+		if body != tt.want {
+			t.Errorf("fetch_url %v body = %q, want %q", tt.args, body, tt.want)
+		}
+	}
+}
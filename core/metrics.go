@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// TurnMetrics are the sizes and counts recorded for one turn. They never
+// include message contents.
+type TurnMetrics struct {
+	Event            string `json:"event"`
+	SessionID        string `json:"session_id"`
+	Time             string `json:"time"`
+	ProviderCalls    int    `json:"provider_calls"`
+	RequestBytes     int    `json:"request_bytes"`
+	ResponseBytes    int    `json:"response_bytes"`
+	ToolCalls        int    `json:"tool_calls"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	Failed           bool   `json:"failed"`
+}
+
+// meteredProvider counts the traffic of every request sent through it.
+// Sizes are those of the JSON-encoded request and response.
+type meteredProvider struct {
+	inner Provider
+	mu    sync.Mutex
+	m     TurnMetrics
+}
+
+func (p *meteredProvider) sendChatRequest(ctx context.Context, reqBody ProviderRequest) (ProviderResponse, error) {
+	reqBytes := 0
+	if payload, err := json.Marshal(reqBody); err == nil {
+		reqBytes = len(payload)
+	}
+	resp, err := p.inner.sendChatRequest(ctx, reqBody)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.m.ProviderCalls++
+	p.m.RequestBytes += reqBytes
+	if err != nil {
+		return resp, err
+	}
+	if payload, err := json.Marshal(resp); err == nil {
+		p.m.ResponseBytes += len(payload)
+	}
+	p.m.ToolCalls += len(resp.Message.ToolCalls)
+	p.m.PromptTokens += resp.PromptTokens
+	p.m.CompletionTokens += resp.CompletionTokens
+	return resp, nil
+}
+
+// openMetricsFromEnv opens the file named by KUTAGENT_METRICS for appending.
+func openMetricsFromEnv() (*os.File, error) {
+	path := os.Getenv("KUTAGENT_METRICS")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open metrics file: %w", err)
+	}
+	return f, nil
+}
+
+// emitMetrics writes the metrics of a finished turn as one JSON line.
+func (agent *Agent) emitMetrics(p *meteredProvider, failed bool) {
+	p.mu.Lock()
+	m := p.m
+	p.mu.Unlock()
+	m.Event = "turn"
+	m.SessionID = agent.session.ID()
+	m.Time = Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	m.TotalTokens = m.PromptTokens + m.CompletionTokens
+	m.Failed = failed
+	line, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	if _, err := agent.MetricsOut.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(agent.ErrOut, "warning: write metrics: %v\n", err)
+	}
+}
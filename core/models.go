@@ -0,0 +1,171 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ModelLister is implemented by providers that can report which models the
+// server has available.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// modelCheckTimeout bounds the model listing done before the first prompt.
+const modelCheckTimeout = 10 * time.Second
+
+// maxTagsBytes bounds the /api/tags response, which lists every pulled
+// model with its details.
+const maxTagsBytes = 4 << 20 // 4MB
+
+// ListModels returns the names of the models the Ollama server has pulled,
+// from /api/tags on the same host as the chat endpoint.
+func (o *Ollama) ListModels(ctx context.Context) ([]string, error) {
+	u, err := tagsURL(o.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range o.headers {
+		req.Header[k] = v
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request ollama: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTagsBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ProviderError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return nil, fmt.Errorf("decode model list: %w", err)
+	}
+	names := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		names = append(names, m.Name)
+	}
+	return names, nil
+}
+
+// tagsURL turns the chat endpoint into the /api/tags URL on the same host,
+// keeping any path prefix in front of /api, e.g. for a reverse proxy.
+func tagsURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid ollama endpoint %q", endpoint)
+	}
+	prefix := ""
+	if i := strings.Index(u.Path, "/api/"); i >= 0 {
+		prefix = u.Path[:i]
+	}
+	u.Path = prefix + "/api/tags"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// hasModel reports whether model is among names. A name without a tag
+// matches the :latest tag, as it does in Ollama.
+func hasModel(names []string, model string) bool {
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+	for _, n := range names {
+		if !strings.Contains(n, ":") {
+			n += ":latest"
+		}
+		if n == model {
+			return true
+		}
+	}
+	return false
+}
+
+// closestModel returns the name in names nearest to model by edit
+// distance, or "" if none is reasonably close.
+func closestModel(names []string, model string) string {
+	best, bestDist := "", -1
+	for _, n := range names {
+		d := editDistance(strings.ToLower(strings.TrimSuffix(model, ":latest")), strings.ToLower(strings.TrimSuffix(n, ":latest")))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	if bestDist < 0 || bestDist > max(len(model)/2, 3) {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b in bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkModel makes sure model has been pulled before the conversation
+// starts, instead of failing on the first request with a raw 404. A server
+// that can't list its models, such as a proxy without /api/tags, only gets
+// a warning. With fallback models configured a missing model is a warning
+// too, since the fallbacks will be tried.
+func (agent *Agent) checkModel(ctx context.Context, provider Provider, model string) error {
+	lister, ok := provider.(ModelLister)
+	if agent.SkipModelCheck || !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, modelCheckTimeout)
+	defer cancel()
+	names, err := lister.ListModels(ctx)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(agent.ErrOut, "warning: could not check that model %s is available: %v (set KUTAGENT_SKIP_MODEL_CHECK to skip this check)\n", model, err)
+		return nil
+	}
+	if hasModel(names, model) {
+		return nil
+	}
+	msg := fmt.Sprintf("model %s not found; run `ollama pull %s`", model, model)
+	if near := closestModel(names, model); near != "" {
+		msg += fmt.Sprintf(" or use %s", near)
+	}
+	if len(agent.FallbackModels) > 0 {
+		fmt.Fprintf(agent.ErrOut, "warning: %s; falling back to %s\n", msg, strings.Join(agent.FallbackModels, ", "))
+		return nil
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when a tool that must only reach the public
+// internet is pointed at this machine or a private network.
+var errPrivateAddress = errors.New("refusing to connect to a local or private network address")
+
+// cgnatPrefix is the shared address space of carrier-grade NAT (RFC 6598),
+// which is not reachable from the internet either.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether addr may be reached by the guarded tools:
+// not loopback, private (RFC 1918, unique local), link-local (including the
+// 169.254.169.254 cloud metadata service), multicast or unspecified.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsUnspecified() &&
+		!cgnatPrefix.Contains(addr)
+}
+
+// publicOnlyControl runs for every connection after DNS resolution, so a
+// public host name resolving to a private address, or a redirect to one, is
+// refused too.
+func publicOnlyControl(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isPublicAddr(addr) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// publicOnlyTransport is pooledTransport dialing only public addresses. It
+// ignores proxy settings, since a proxy would make the connections the
+// guard can't see.
+func publicOnlyTransport(idle int) *http.Transport {
+	t := pooledTransport(idle)
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnlyControl,
+	}).DialContext
+	return t
+}
+
+// publicHTTPClient is toolHTTPClient for tools that fetch URLs the model
+// chooses but must not reach the local machine or private networks, such as
+// cloud metadata endpoints or services on the LAN.
+var publicHTTPClient = &http.Client{Transport: newLimitedTransport(publicOnlyTransport(maxHTTPConnsFromEnv()), maxHTTPConnsFromEnv())}
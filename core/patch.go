@@ -0,0 +1,190 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// patchHunk is one @@ section of a unified diff.
+type patchHunk struct {
+	header   string
+	oldStart int // 1-based, as written in the header
+	old      []string
+	new      []string
+}
+
+// parsePatch reads the hunks of a unified diff for a single file. File
+// headers (---, +++, diff, index) are skipped; the hunk line counts are not
+// trusted, since the lines themselves say what the hunk contains.
+func parsePatch(patch string) ([]patchHunk, error) {
+	patch = strings.TrimRight(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	lines := strings.Split(patch, "\n")
+	var hunks []patchHunk
+	var cur *patchHunk
+	files := 0
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			files++
+			if files > 1 {
+				return nil, fmt.Errorf("patch changes more than one file; send one patch per file")
+			}
+			cur = nil
+			i++
+		case strings.HasPrefix(line, "@@"):
+			start, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			hunks = append(hunks, patchHunk{header: line, oldStart: start})
+			cur = &hunks[len(hunks)-1]
+		case cur == nil:
+			// diff/index lines or text before the first hunk
+		case strings.HasPrefix(line, "-"):
+			cur.old = append(cur.old, line[1:])
+		case strings.HasPrefix(line, "+"):
+			cur.new = append(cur.new, line[1:])
+		case strings.HasPrefix(line, " "):
+			cur.old = append(cur.old, line[1:])
+			cur.new = append(cur.new, line[1:])
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file"
+		case line == "":
+			// An empty context line whose leading space was lost
+			cur.old = append(cur.old, "")
+			cur.new = append(cur.new, "")
+		default:
+			return nil, fmt.Errorf("line %d of the patch is not part of a hunk: %q", i+1, line)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("patch has no hunks; expected unified diff format with @@ -start,count +start,count @@ headers")
+	}
+	return hunks, nil
+}
+
+// parseHunkHeader returns the old start line of "@@ -a,b +c,d @@".
+func parseHunkHeader(line string) (int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+	start, _, _ := strings.Cut(fields[1][1:], ",")
+	n, err := strconv.Atoi(start)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+	return n, nil
+}
+
+// applyHunks applies hunks to lines in order. Each hunk must match the
+// current content exactly: at the line its header names, or else at a
+// single other place after the previous hunk, as when lines were added
+// above it since the diff was made.
+func applyHunks(lines []string, hunks []patchHunk) ([]string, error) {
+	out := make([]string, 0, len(lines))
+	pos := 0 // lines before pos have been copied to out
+	for i, h := range hunks {
+		at := max(h.oldStart-1, 0)
+		if len(h.old) == 0 {
+			// Pure insertion: the header names the line it follows
+			at = min(h.oldStart, len(lines))
+		}
+		if at < pos || !linesMatch(lines, at, h.old) {
+			at = -1
+			if len(h.old) > 0 {
+				for j := pos; j+len(h.old) <= len(lines); j++ {
+					if !linesMatch(lines, j, h.old) {
+						continue
+					}
+					if at >= 0 {
+						return nil, fmt.Errorf("hunk %d (%s) does not match at line %d and its lines occur more than once; nothing was written", i+1, h.header, h.oldStart)
+					}
+					at = j
+				}
+			}
+			if at < 0 {
+				return nil, fmt.Errorf("hunk %d (%s) does not match the file: %s; re-read the file and make the patch again; nothing was written", i+1, h.header, hunkMismatch(lines, max(h.oldStart-1, pos), h.old))
+			}
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.new...)
+		pos = at + len(h.old)
+	}
+	return append(out, lines[pos:]...), nil
+}
+
+func linesMatch(lines []string, at int, want []string) bool {
+	if at < 0 || at+len(want) > len(lines) {
+		return false
+	}
+	for i, l := range want {
+		if lines[at+i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// hunkMismatch describes the first line at which want differs from lines
+// starting at at.
+func hunkMismatch(lines []string, at int, want []string) string {
+	for i, l := range want {
+		if at+i >= len(lines) {
+			return fmt.Sprintf("expected %q at line %d, past the end of the file (%d lines)", l, at+i+1, len(lines))
+		}
+		if lines[at+i] != l {
+			return fmt.Sprintf("expected %q at line %d, found %q", l, at+i+1, lines[at+i])
+		}
+	}
+	return "the hunk overlaps the one before it"
+}
+
+// applyPatch applies a unified diff to the file at path. The file is only
+// written if every hunk matches; a missing file is treated as empty, so a
+// patch can create one.
+func applyPatch(path, patch string) (string, error) {
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return "", err
+	}
+	perm := os.FileMode(0o644)
+	var text string
+	fi, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if fi.IsDir() {
+			return "", fmt.Errorf("path is a directory, not a file")
+		}
+		perm = fi.Mode().Perm()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read file: %w", err)
+		}
+		text = string(data)
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+	lines := splitLines(text)
+	out, err := applyHunks(lines, hunks)
+	if err != nil {
+		return "", err
+	}
+	result := strings.Join(out, "\n")
+	if len(out) > 0 && (text == "" || strings.HasSuffix(text, "\n")) {
+		result += "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("create parent directories: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(result), perm); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("applied %d hunks to %s; file now has %d lines", len(hunks), filepath.Base(path), len(out)), nil
+}
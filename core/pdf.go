@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// maxPDFBytes bounds the PDF itself; the extracted text is bounded by
+// Limits.FileBytes.
+const maxPDFBytes = 20 << 20 // 20MB
+
+// looksLikeText reports whether s is mostly readable characters, to tell
+// real text from glyph ids of fonts with custom encodings.
+func looksLikeText(s string) bool {
+	total, good := 0, 0
+	for _, r := range s {
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			if r < 0x80 || unicode.IsLetter(r) {
+				good++
+			}
+		}
+	}
+	return total > 0 && float64(good)/float64(total) > 0.85
+}
+
+// pdfToText extracts the text of each page, marking page boundaries, and
+// stops once the output passes maxOutput bytes.
+func pdfToText(data []byte, maxOutput int) (_ string, err error) {
+	// The parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("parse pdf: %w", err)
+	}
+	pages := r.NumPage()
+	var b strings.Builder
+	found := false
+	for i := 1; i <= pages; i++ {
+		text, err := r.Page(i).GetPlainText(nil)
+		if err != nil {
+			text = fmt.Sprintf("(could not extract text: %v)", err)
+		}
+		text = strings.TrimSpace(text)
+		if text != "" && !looksLikeText(text) {
+			text = "(text on this page uses an encoding that can't be extracted)"
+		} else if text != "" {
+			found = true
+		}
+		fmt.Fprintf(&b, "--- page %d ---\n%s\n", i, text)
+		if b.Len() > maxOutput {
+			return cutAtRune(b.String(), maxOutput) + truncationNotice(maxOutput), nil
+		}
+	}
+	if !found {
+		return fmt.Sprintf("no extractable text found in %d pages; the PDF may contain only scanned images", pages), nil
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// fetchPDF downloads a PDF from the public internet; local and private
+// network addresses are refused.
+func fetchPDF(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "KutAgent/1.0 (+https://example.com)")
+	resp, err := publicHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch pdf: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPDFBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if len(data) > maxPDFBytes {
+		return nil, fmt.Errorf("pdf too large (limit %d bytes)", maxPDFBytes)
+	}
+	return data, nil
+}
+
+func readPDFFile(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat file: %w", err)
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("path is a directory, not a file")
+	}
+	if fi.Size() > maxPDFBytes {
+		return nil, fmt.Errorf("file too large: %d bytes (limit %d)", fi.Size(), maxPDFBytes)
+	}
+	return os.ReadFile(path)
+}
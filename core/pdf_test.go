@@ -0,0 +1,105 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// buildPDF writes a minimal PDF with one page per content stream. Pages
+// can use the font /F1 and the 1x1 grey image /Im1.
+func buildPDF(contents ...string) []byte {
+	var objs []string
+	kids := make([]string, len(contents))
+	// 1 catalog, 2 page tree, 3 font, 4 image, then a page and its
+	// contents for each page
+	for i := range contents {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objs = append(objs,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(contents)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x80\nendstream",
+	)
+	for i, c := range contents {
+		objs = append(objs,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> /XObject << /Im1 4 0 R >> >> /Contents %d 0 R >>", 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(c), c),
+		)
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, obj := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return b.Bytes()
+}
+
+func TestPDFToText(t *testing.T) {
+	data := buildPDF(
+		"BT /F1 12 Tf 72 720 Td (Hello PDF) Tj ET",
+		"BT /F1 12 Tf 72 720 Td (Second page) Tj ET",
+	)
+	got, err := pdfToText(data, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--- page 1 ---\nHello PDF", "--- page 2 ---\nSecond page"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("pdfToText() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestPDFToTextImageOnly(t *testing.T) {
+	data := buildPDF("q 612 0 0 792 0 0 cm /Im1 Do Q")
+	got, err := pdfToText(data, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "no extractable text found in 1 pages") {
+		t.Errorf("pdfToText() = %q, want the no extractable text message", got)
+	}
+}
+
+func TestPDFToTextLimit(t *testing.T) {
+	data := buildPDF("BT /F1 12 Tf 72 720 Td (" + strings.Repeat("long text ", 50) + ") Tj ET")
+	got, err := pdfToText(data, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(got, truncationNotice(100)) || len(got) > 100+len(truncationNotice(100)) {
+		t.Errorf("pdfToText() with a 100 byte limit = %q", got)
+	}
+}
+
+func TestPDFToTextInvalid(t *testing.T) {
+	if _, err := pdfToText([]byte("not a pdf"), 1<<20); err == nil {
+		t.Error("pdfToText accepted a file that is not a PDF")
+	}
+}
+
+func TestFetchPDFRefusesLocalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buildPDF("BT /F1 12 Tf (secret) Tj ET"))
+	}))
+	defer srv.Close()
+	_, err := fetchPDF(context.Background(), srv.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("fetchPDF(%s) error = %v, want errPrivateAddress", srv.URL, err)
+	}
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// sessionFormatVersion is bumped whenever savedSession changes incompatibly.
+const sessionFormatVersion = 1
+
+// savedSession is the on-disk form of a Session.
+type savedSession struct {
+	Version   int                      `json:"version"`
+	ID        string                   `json:"id"`
+	Metadata  Metadata                 `json:"metadata"`
+	Branch    string                   `json:"branch"`
+	Messages  []UserMessage            `json:"messages"`
+	Branches  map[string][]UserMessage `json:"branches,omitempty"`
+	Plan      Plan                     `json:"plan,omitempty"`
+	Bookmarks Bookmarks                `json:"bookmarks,omitempty"`
+	Usage     Usage                    `json:"usage"`
+}
+
+// SaveSession writes the conversation, including its branches, plan,
+// bookmarks, metadata and usage, to path as JSON so it can be resumed with
+// LoadSession. The file is replaced atomically and only readable by its
+// owner, since conversations may contain file contents and command output.
+func (agent *Agent) SaveSession(path string) error {
+	s := agent.session
+	saved := savedSession{
+		Version:   sessionFormatVersion,
+		ID:        s.id,
+		Metadata:  s.meta,
+		Branch:    s.current,
+		Messages:  s.Messages,
+		Branches:  s.branches,
+		Plan:      s.Plan,
+		Bookmarks: s.Bookmarks,
+		Usage:     s.Usage,
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create session directory: %w", err)
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+// LoadSession replaces the conversation with the one saved at path. The
+// history is validated first, so a file that was edited by hand can't leave
+// the agent with tool results that answer nothing.
+func (agent *Agent) LoadSession(path string) error {
+	saved, err := readSavedSession(path)
+	if err != nil {
+		return err
+	}
+	s := NewSession()
+	if saved.ID != "" {
+		s.id = saved.ID
+	}
+	if saved.Branch != "" {
+		s.current = saved.Branch
+	}
+	if !saved.Metadata.Created.IsZero() {
+		s.meta = saved.Metadata
+	}
+	s.Messages = saved.Messages
+	if saved.Branches != nil {
+		s.branches = saved.Branches
+	}
+	s.Plan = saved.Plan
+	s.Bookmarks = saved.Bookmarks
+	s.Usage = saved.Usage
+	agent.session = s
+	return nil
+}
+
+func readSavedSession(path string) (*savedSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read session: %w", err)
+	}
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("decode session %s: %w", path, err)
+	}
+	if saved.Version != sessionFormatVersion {
+		return nil, fmt.Errorf("session %s has unsupported format version %d", path, saved.Version)
+	}
+	if err := validateHistory(saved.Messages); err != nil {
+		return nil, fmt.Errorf("session %s: %w", path, err)
+	}
+	for name, msgs := range saved.Branches {
+		if err := validateHistory(msgs); err != nil {
+			return nil, fmt.Errorf("session %s, branch %s: %w", path, name, err)
+		}
+	}
+	return &saved, nil
+}
+
+// SessionInfo describes a saved session for browsing.
+type SessionInfo struct {
+	Path     string
+	ID       string
+	Metadata Metadata
+	Messages int
+}
+
+// ListSessions returns the sessions saved as .json files in dir, most
+// recently updated first. With a tag, only sessions carrying it are listed.
+// Files that aren't valid sessions are skipped.
+func ListSessions(dir, tag string) ([]SessionInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var infos []SessionInfo
+	for _, path := range paths {
+		saved, err := readSavedSession(path)
+		if err != nil {
+			continue
+		}
+		s := &Session{Messages: saved.Messages, meta: saved.Metadata}
+		if tag != "" && !s.HasTag(tag) {
+			continue
+		}
+		infos = append(infos, SessionInfo{Path: path, ID: saved.ID, Metadata: s.Metadata(), Messages: len(saved.Messages)})
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Metadata.Updated.After(infos[j].Metadata.Updated)
+	})
+	return infos, nil
+}
+
+// resumeSession loads SessionPath if it exists; a missing file just means a
+// new session that will be saved there.
+func (agent *Agent) resumeSession() error {
+	if agent.SessionPath == "" {
+		return nil
+	}
+	if _, err := os.Stat(agent.SessionPath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := agent.LoadSession(agent.SessionPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(agent.out(), "resumed session %s (%d messages)\n", agent.SessionPath, len(agent.session.Messages))
+	return nil
+}
+
+// autosave saves the session to SessionPath, if set, warning on failure
+// rather than ending the conversation.
+func (agent *Agent) autosave() {
+	if agent.SessionPath == "" {
+		return
+	}
+	if err := agent.SaveSession(agent.SessionPath); err != nil {
+		fmt.Fprintf(agent.ErrOut, "warning: save session: %v\n", err)
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type PlanStep struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// Plan is the checklist the model keeps for the current task.
+type Plan []PlanStep
+
+func (p Plan) String() string {
+	if len(p) == 0 {
+		return "no plan"
+	}
+	var b strings.Builder
+	for i, step := range p {
+		mark := " "
+		if step.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(&b, "%d. [%s] %s\n", i+1, mark, step.Text)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// planTools are the tools the model keeps its plan with. Changes to the
+// plan are shown to the user.
+func (agent *Agent) planTools() []builtinTool {
+	return []builtinTool{
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "plan_set",
+					Description: "Write down the plan for a multi-step task as a list of steps, replacing any previous plan. Input: { steps: string[] }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"steps": map[string]any{
+								"type":  "array",
+								"items": map[string]any{"type": "string"},
+							},
+						},
+						"required":             []string{"steps"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.planSetTool,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "plan_complete",
+					Description: "Mark a step of the plan as done. Input: { index: integer } (1-based)",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"index": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"index"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.planCompleteTool,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "plan_show",
+					Description: "Show the current plan and which steps are done",
+					Parameters: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: agent.planShowTool,
+		},
+	}
+}
+func (agent *Agent) planSetTool(_ context.Context, args map[string]any) (string, error) {
+	list, _ := args["steps"].([]any)
+	plan := make(Plan, 0, len(list))
+	for _, v := range list {
+		if text, ok := v.(string); ok && strings.TrimSpace(text) != "" {
+			plan = append(plan, PlanStep{Text: strings.TrimSpace(text)})
+		}
+	}
+	if len(plan) == 0 {
+		return "", fmt.Errorf("steps must contain at least one step")
+	}
+	agent.session.Plan = plan
+	return agent.showPlan(), nil
+}
+
+func (agent *Agent) planCompleteTool(_ context.Context, args map[string]any) (string, error) {
+	index := intArg(args, "index", 0)
+	if index < 1 || index > len(agent.session.Plan) {
+		return "", fmt.Errorf("index must be between 1 and %d", len(agent.session.Plan))
+	}
+	agent.session.Plan[index-1].Done = true
+	return agent.showPlan(), nil
+}
+
+func (agent *Agent) planShowTool(_ context.Context, _ map[string]any) (string, error) {
+	return agent.session.Plan.String(), nil
+}
+
+// showPlan shows the changed plan to the user and returns it for the model.
+func (agent *Agent) showPlan() string {
+	fmt.Fprintln(agent.out(), "\u001b[96mPlan\u001b[0m:\n"+agent.session.Plan.String())
+	return agent.session.Plan.String()
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// probeURL checks that rawURL answers and how fast, without downloading the
+// body. It tries HEAD first and falls back to a GET for the first byte when
+// the server doesn't support HEAD. The tool passes publicHTTPClient, so
+// local and private network addresses can't be probed.
+func probeURL(ctx context.Context, client *http.Client, rawURL string, timeout time.Duration) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	method := http.MethodHead
+	start := time.Now()
+	resp, err := probeRequest(ctx, client, method, rawURL)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		method = http.MethodGet
+		start = time.Now()
+		resp, err = probeRequest(ctx, client, method, rawURL)
+	}
+	if err != nil {
+		return "", fmt.Errorf("probe %s: %w", rawURL, err)
+	}
+	elapsed := time.Since(start)
+	// The body is never read; closing it drops the connection
+	resp.Body.Close()
+
+	lines := []string{
+		fmt.Sprintf("status=%d", resp.StatusCode),
+		fmt.Sprintf("method=%s", method),
+		fmt.Sprintf("response_time=%s", elapsed.Round(time.Millisecond)),
+		fmt.Sprintf("final_url=%s", resp.Request.URL),
+	}
+	if server := resp.Header.Get("Server"); server != "" {
+		lines = append(lines, fmt.Sprintf("server=%q", server))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func probeRequest(ctx context.Context, client *http.Client, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "KutAgent/1.0 (+https://example.com)")
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeURL(t *testing.T) {
+	var heads, gets int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test-server/1.0")
+		if r.Method != http.MethodHead {
+			t.Errorf("/ok got %s, want HEAD", r.Method)
+		}
+	})
+	mux.HandleFunc("/nohead", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		gets++
+		if got := r.Header.Get("Range"); got != "bytes=0-0" {
+			t.Errorf("GET fallback Range = %q, want bytes=0-0", got)
+		}
+		w.WriteHeader(http.StatusPartialContent)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/ok", []string{"status=200", "method=HEAD", "final_url=" + srv.URL + "/ok", `server="test-server/1.0"`}},
+		{"/nohead", []string{"status=206", "method=GET", "final_url=" + srv.URL + "/nohead"}},
+		{"/redirect", []string{"status=200", "method=HEAD", "final_url=" + srv.URL + "/ok"}},
+		{"/missing", []string{"status=404", "method=HEAD"}},
+	}
+	for _, tt := range tests {
+		got, err := probeURL(context.Background(), srv.Client(), srv.URL+tt.path, 5*time.Second)
+		if err != nil {
+			t.Errorf("probeURL(%s): %v", tt.path, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want+"\n") && !strings.HasSuffix(got, want) {
+				t.Errorf("probeURL(%s) = %q, want a %s line", tt.path, got, want)
+			}
+		}
+		if strings.Contains(got, "server=") && tt.path != "/ok" && tt.path != "/redirect" {
+			t.Errorf("probeURL(%s) reported a server header it didn't get: %q", tt.path, got)
+		}
+	}
+	if heads != 1 || gets != 1 {
+		t.Errorf("/nohead got %d HEAD and %d GET requests, want 1 each", heads, gets)
+	}
+}
+
+func TestProbeURLRefusesLocalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	_, err := probeURL(context.Background(), publicHTTPClient, srv.URL, 5*time.Second)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("probeURL(%s) error = %v, want errPrivateAddress", srv.URL, err)
+	}
+}
+
+func TestProbeURLInvalid(t *testing.T) {
+	for _, u := range []string{"", "example.com", "ftp://example.com/"} {
+		if _, err := probeURL(context.Background(), publicHTTPClient, u, time.Second); err == nil {
+			t.Errorf("probeURL(%q) succeeded", u)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxProcesses       = 8
+	maxExitedProcesses = 8       // exited processes kept for their final output
+	maxProcessBuffer   = 1 << 20 // 1MB of unread output per process
+	maxExpectTimeout   = 300
+	expectPollInterval = 50 * time.Millisecond
+)
+
+// bgProcess is a long-running command started with start_process. Its
+// output is buffered until the model reads it with expect_output.
+type bgProcess struct {
+	id      string
+	seq     int
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	buf     []byte
+	dropped int
+	reaped  chan struct{} // closed once the shell has been waited for
+	done    chan struct{} // closed once all output has been read, too
+	waitErr error
+}
+
+func (p *bgProcess) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	if over := len(p.buf) - maxProcessBuffer; over > 0 {
+		p.buf = p.buf[over:]
+		p.dropped += over
+	}
+	return len(b), nil
+}
+
+func (p *bgProcess) exited() bool {
+	return closed(p.done)
+}
+
+func closed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+type processTable struct {
+	mu    sync.Mutex
+	procs map[string]*bgProcess
+	next  int
+}
+
+func newProcessTable() *processTable {
+	return &processTable{procs: map[string]*bgProcess{}}
+}
+
+type processTableKey struct{}
+
+// withProcessTable makes t hold the background processes of the tools run
+// with ctx, so each agent only sees and stops its own.
+func withProcessTable(ctx context.Context, t *processTable) context.Context {
+	return context.WithValue(ctx, processTableKey{}, t)
+}
+
+func processTableFrom(ctx context.Context) (*processTable, error) {
+	t, ok := ctx.Value(processTableKey{}).(*processTable)
+	if !ok {
+		return nil, fmt.Errorf("background processes are only available to an agent")
+	}
+	return t, nil
+}
+
+// start launches command via sh -c in dir with its own process group,
+// detached from ctx so it outlives the tool call that started it.
+func (t *processTable) start(dir, command string) (*bgProcess, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.evictExitedLocked()
+	running := 0
+	for _, p := range t.procs {
+		if !p.exited() {
+			running++
+		}
+	}
+	if running >= maxProcesses {
+		return nil, fmt.Errorf("%w (limit %d)", errProcessLimit, maxProcesses)
+	}
+	t.next++
+	p := &bgProcess{
+		id:      fmt.Sprintf("proc-%d", t.next),
+		seq:     t.next,
+		command: command,
+		reaped:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	setProcessGroup(cmd)
+	// With an *os.File for output, Wait returns as soon as the shell is
+	// reaped rather than when every child has closed the pipe, so stop
+	// knows whether the group id can still be trusted.
+	out, outW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("output pipe: %w", err)
+	}
+	cmd.Stdout = outW
+	cmd.Stderr = outW
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		out.Close()
+		outW.Close()
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	err = cmd.Start()
+	outW.Close()
+	if err != nil {
+		out.Close()
+		return nil, fmt.Errorf("start process: %w", err)
+	}
+	p.cmd, p.stdin = cmd, stdin
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(p, out)
+		out.Close()
+		close(copied)
+	}()
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.reaped)
+		<-copied
+		close(p.done)
+	}()
+	t.procs[p.id] = p
+	return p, nil
+}
+
+// evictExitedLocked forgets the oldest exited processes beyond
+// maxExitedProcesses, each of which may hold up to maxProcessBuffer of
+// unread output. t.mu must be held.
+func (t *processTable) evictExitedLocked() {
+	var exited []*bgProcess
+	for _, p := range t.procs {
+		if p.exited() {
+			exited = append(exited, p)
+		}
+	}
+	if len(exited) <= maxExitedProcesses {
+		return
+	}
+	sort.Slice(exited, func(i, j int) bool { return exited[i].seq < exited[j].seq })
+	for _, p := range exited[:len(exited)-maxExitedProcesses] {
+		delete(t.procs, p.id)
+	}
+}
+
+func (t *processTable) get(id string) (*bgProcess, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown process id: %s", id)
+	}
+	return p, nil
+}
+
+func (t *processTable) list() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.procs) == 0 {
+		return "no processes"
+	}
+	ids := make([]string, 0, len(t.procs))
+	for id := range t.procs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		p := t.procs[id]
+		state := "running"
+		if p.exited() {
+			state = fmt.Sprintf("exited (%d)", p.cmd.ProcessState.ExitCode())
+		}
+		fmt.Fprintf(&b, "%s %s: %s\n", id, state, p.command)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// stop kills the process group of id and forgets the process.
+func (t *processTable) stop(id string) error {
+	p, err := t.get(id)
+	if err != nil {
+		return err
+	}
+	// Kill the group even if the shell itself has exited: children it put
+	// in the background may still be running. reaped is closed just after
+	// Wait returns, so in that short window the group is signalled as if
+	// the shell were still unreaped.
+	killProcessGroup(p.cmd, closed(p.reaped))
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("process %s did not exit", id)
+	}
+	t.mu.Lock()
+	delete(t.procs, id)
+	t.mu.Unlock()
+	return nil
+}
+
+// stopAll stops every process in the table.
+func (t *processTable) stopAll() {
+	t.mu.Lock()
+	ids := make([]string, 0, len(t.procs))
+	for id := range t.procs {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+	for _, id := range ids {
+		_ = t.stop(id)
+	}
+}
+
+func (p *bgProcess) send(text string) error {
+	if p.exited() {
+		return fmt.Errorf("process %s has exited", p.id)
+	}
+	if _, err := io.WriteString(p.stdin, text); err != nil {
+		return fmt.Errorf("write stdin: %w", err)
+	}
+	return nil
+}
+
+// expect waits until pattern matches the unread output, then returns the
+// output up to the end of the match and consumes it. On timeout or exit it
+// returns whatever is unread.
+func (p *bgProcess) expect(ctx context.Context, pattern *regexp.Regexp, timeout time.Duration) (string, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(expectPollInterval)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		if loc := pattern.FindIndex(p.buf); loc != nil {
+			out := p.takeLocked(loc[1])
+			p.mu.Unlock()
+			return "matched\n" + out, nil
+		}
+		p.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline.C:
+			return fmt.Sprintf("timed out after %s without a match\n%s", timeout, p.take()), nil
+		case <-p.done:
+			// Check once more: the final output may contain the match
+			p.mu.Lock()
+			if loc := pattern.FindIndex(p.buf); loc != nil {
+				out := p.takeLocked(loc[1])
+				p.mu.Unlock()
+				return "matched\n" + out, nil
+			}
+			p.mu.Unlock()
+			return fmt.Sprintf("process exited (%d) without a match\n%s", p.cmd.ProcessState.ExitCode(), p.take()), nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *bgProcess) take() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.takeLocked(len(p.buf))
+}
+
+func (p *bgProcess) takeLocked(n int) string {
+	out := string(p.buf[:n])
+	if p.dropped > 0 {
+		out = fmt.Sprintf("... %d earlier bytes dropped ...\n", p.dropped) + out
+		p.dropped = 0
+	}
+	p.buf = append([]byte(nil), p.buf[n:]...)
+	return out
+}
+
+// processTools returns the background process tools.
+func processTools() []builtinTool {
+	return []builtinTool{
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "start_process",
+					Description: "Start a long-running or interactive shell command in the background and return its id. Drive it with send_input and expect_output. Input: { command: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"command": map[string]any{"type": "string"},
+						},
+						"required":             []string{"command"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolStartProcess,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "send_input",
+					Description: "Write text to the stdin of a background process. Include a trailing newline to submit a line. Input: { id: string, text: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"id":   map[string]any{"type": "string"},
+							"text": map[string]any{"type": "string"},
+						},
+						"required":             []string{"id", "text"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolSendInput,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "expect_output",
+					Description: "Wait until the unread output of a background process matches the regex pattern and return the output up to the match. Input: { id: string, pattern: string, timeout_sec?: integer }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"id":          map[string]any{"type": "string"},
+							"pattern":     map[string]any{"type": "string"},
+							"timeout_sec": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"id", "pattern"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolExpectOutput,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "stop_process",
+					Description: "Kill a background process and everything it started. Input: { id: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"id": map[string]any{"type": "string"},
+						},
+						"required":             []string{"id"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolStopProcess,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "list_processes",
+					Description: "List background processes with their state and command",
+					Parameters: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolListProcesses,
+		},
+	}
+}
+
+func toolStartProcess(ctx context.Context, args map[string]any) (string, error) {
+	command, _ := args["command"].(string)
+	if command == "" {
+		return "", fmt.Errorf("missing required argument: command")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	processes, err := processTableFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := processes.start(root, command)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("started %s", p.id), nil
+}
+
+func toolSendInput(ctx context.Context, args map[string]any) (string, error) {
+	id, _ := args["id"].(string)
+	text, _ := args["text"].(string)
+	processes, err := processTableFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := processes.get(id)
+	if err != nil {
+		return "", err
+	}
+	if err := p.send(text); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sent %d bytes", len(text)), nil
+}
+
+func toolExpectOutput(ctx context.Context, args map[string]any) (string, error) {
+	id, _ := args["id"].(string)
+	pattern, _ := args["pattern"].(string)
+	if pattern == "" {
+		return "", fmt.Errorf("missing required argument: pattern")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	processes, err := processTableFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := processes.get(id)
+	if err != nil {
+		return "", err
+	}
+	timeoutSec := intArg(args, "timeout_sec", 10)
+	if timeoutSec > maxExpectTimeout {
+		timeoutSec = maxExpectTimeout
+	}
+	return p.expect(ctx, re, time.Duration(timeoutSec)*time.Second)
+}
+
+func toolStopProcess(ctx context.Context, args map[string]any) (string, error) {
+	id, _ := args["id"].(string)
+	processes, err := processTableFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := processes.stop(id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("stopped %s", id), nil
+}
+
+func toolListProcesses(ctx context.Context, args map[string]any) (string, error) {
+	processes, err := processTableFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	return processes.list(), nil
+}
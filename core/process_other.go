@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package core
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd, reaped bool) {
+	if cmd.Process == nil || reaped {
+		return
+	}
+	_ = cmd.Process.Kill()
+}
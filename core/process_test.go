@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func waitExited(t *testing.T, p *bgProcess) {
+	t.Helper()
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not exit", p.id)
+	}
+}
+
+func TestProcessTableEvictsExited(t *testing.T) {
+	table := newProcessTable()
+	defer table.stopAll()
+	var first *bgProcess
+	for i := 0; i < maxExitedProcesses+2; i++ {
+		p, err := table.start(t.TempDir(), "true")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = p
+		}
+		waitExited(t, p)
+	}
+	// Eviction happens when the next process starts
+	if _, err := table.start(t.TempDir(), "true"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(table.procs); n > maxExitedProcesses+1 {
+		t.Errorf("table holds %d processes, want at most %d", n, maxExitedProcesses+1)
+	}
+	if _, err := table.get(first.id); err == nil {
+		t.Errorf("oldest exited process %s was kept", first.id)
+	}
+}
+
+func TestProcessTableStopAll(t *testing.T) {
+	table := newProcessTable()
+	var started []*bgProcess
+	for i := 0; i < 3; i++ {
+		p, err := table.start(t.TempDir(), "sleep 60")
+		if err != nil {
+			t.Fatal(err)
+		}
+		started = append(started, p)
+	}
+	table.stopAll()
+	for _, p := range started {
+		if !p.exited() {
+			t.Errorf("%s still running after stopAll", p.id)
+		}
+	}
+	if got := table.list(); got != "no processes" {
+		t.Errorf("list() after stopAll = %q", got)
+	}
+}
+
+func TestProcessesPerAgent(t *testing.T) {
+	root, _ := newRoot(t)
+	a := &Agent{session: &Session{}, root: root}
+	b := &Agent{session: &Session{}, root: root}
+	t.Cleanup(a.StopProcesses)
+	t.Cleanup(b.StopProcesses)
+	run := func(agent *Agent, name string, args map[string]any) string {
+		t.Helper()
+		out, err := agent.runTool(context.Background(), call(name, args), false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return out
+	}
+
+	run(a, "start_process", map[string]any{"command": "sleep 60"})
+	if got := run(b, "list_processes", nil); got != "no processes" {
+		t.Errorf("another agent lists %q", got)
+	}
+	if _, err := b.runTool(context.Background(), call("stop_process", map[string]any{"id": "proc-1"}), false); err == nil {
+		t.Error("another agent stopped proc-1")
+	}
+
+	run(b, "start_process", map[string]any{"command": "sleep 60"})
+	b.StopProcesses()
+	// The first agent's process keeps running across its turns
+	if got := run(a, "list_processes", nil); got != "proc-1 running: sleep 60" {
+		t.Errorf("after the other agent stopped its processes: %q", got)
+	}
+	a.StopProcesses()
+	if got := run(a, "list_processes", nil); got != "no processes" {
+		t.Errorf("after StopProcesses: %q", got)
+	}
+}
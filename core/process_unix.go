@@ -0,0 +1,31 @@
+//go:build unix
+
+package core
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup puts the command in its own process group so that
+// killProcessGroup also reaches the children it spawns.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group led by cmd. reaped says whether
+// cmd has been waited for: the leader's pid, which is also the group id,
+// may then be reused. While any member of the group is alive the pid stays
+// reserved, so a live process with that pid means the group is gone and the
+// id belongs to someone else.
+func killProcessGroup(cmd *exec.Cmd, reaped bool) {
+	if cmd.Process == nil {
+		return
+	}
+	pgid := cmd.Process.Pid
+	if reaped && !errors.Is(syscall.Kill(pgid, 0), syscall.ESRCH) {
+		return
+	}
+	_ = syscall.Kill(-pgid, syscall.SIGKILL)
+}
@@ -0,0 +1,77 @@
+//go:build unix
+
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processGone reports whether pid has exited; a zombie waiting for its new
+// parent to reap it counts as gone.
+func processGone(pid int) bool {
+	if errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+		return true
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name
+	s := string(stat)
+	return strings.HasPrefix(s[strings.LastIndexByte(s, ')')+1:], " Z")
+}
+
+func TestStopKillsForkedChild(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+	}{
+		{"shell running", "sleep 60 & echo pid=$!; wait"},
+		{"shell exited", "sleep 60 & echo pid=$!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newProcessTable()
+			defer table.stopAll()
+			p, err := table.start(t.TempDir(), tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := p.expect(context.Background(), regexp.MustCompile(`pid=\d+\n`), 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := regexp.MustCompile(`pid=(\d+)`).FindStringSubmatch(out)
+			if m == nil {
+				t.Fatalf("no child pid in %q", out)
+			}
+			child, _ := strconv.Atoi(m[1])
+			if tt.name == "shell exited" {
+				select {
+				case <-p.reaped:
+				case <-time.After(5 * time.Second):
+					t.Fatal("shell was not reaped")
+				}
+			}
+			if err := table.stop(p.id); err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !processGone(child) {
+				if time.Now().After(deadline) {
+					syscall.Kill(child, syscall.SIGKILL)
+					t.Fatalf("child %d still running after stop", child)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
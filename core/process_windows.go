@@ -0,0 +1,28 @@
+//go:build windows
+
+package core
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a new process group so it doesn't
+// receive the console's Ctrl-C meant for the agent.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killProcessGroup kills the process and its descendants. Windows has no
+// signal for a whole group, so taskkill walks the process tree. Once cmd
+// has been waited for its pid may belong to another process, and the tree
+// can no longer be found from it.
+func killProcessGroup(cmd *exec.Cmd, reaped bool) {
+	if cmd.Process == nil || reaped {
+		return
+	}
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		_ = cmd.Process.Kill()
+	}
+}
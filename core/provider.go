@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Provider interface {
@@ -27,18 +34,191 @@ type ProviderResponse struct {
 	Message    AgentMessage `json:"message"`
 	Done       bool         `json:"done"`
 	DoneReason string       `json:"done_reason"`
+	// Usage holds the token counts and duration Ollama reports.
+	Usage
+}
+
+// ErrRequestTooLarge is returned when a chat request exceeds the provider's
+// maximum request size, usually because the history has grown too long.
+var ErrRequestTooLarge = errors.New("chat request too large")
+
+// defaultMaxRequestBytes is used unless OLLAMA_MAX_REQUEST_BYTES is set.
+const defaultMaxRequestBytes = 8 << 20 // 8MB
+
+// ProviderError is returned when the provider answers with a non-200 status.
+type ProviderError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("ollama error: status %d, body: %s", e.StatusCode, e.Body)
+}
+
+// isModelLoading reports whether err looks like Ollama still loading the
+// model into memory: the request timed out, the server said it is busy, or
+// the runner hadn't started yet.
+func isModelLoading(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pe *ProviderError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	body := strings.ToLower(pe.Body)
+	switch pe.StatusCode {
+	case http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError:
+		return strings.Contains(body, "loading") || strings.Contains(body, "llama runner")
+	}
+	return false
+}
+
+// ollamaOOMErrors are the messages Ollama reports when a model doesn't fit
+// in memory, lowercased.
+var ollamaOOMErrors = []string{
+	"requires more system memory",
+	"out of memory",
+	"cudamalloc failed",
+}
+
+// modelErrorReason says why err is a failure of the model itself that
+// another model might not have: "model not found", "out of memory" or
+// "server overloaded". It returns "" for any other error.
+func modelErrorReason(err error) string {
+	var pe *ProviderError
+	if !errors.As(err, &pe) {
+		return ""
+	}
+	body := strings.ToLower(pe.Body)
+	switch pe.StatusCode {
+	case http.StatusNotFound:
+		// e.g. model "llama3" not found, try pulling it first
+		if strings.Contains(body, "model") && strings.Contains(body, "not found") {
+			return "model not found"
+		}
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return "server overloaded"
+	case http.StatusInternalServerError:
+		for _, msg := range ollamaOOMErrors {
+			if strings.Contains(body, msg) {
+				return "out of memory"
+			}
+		}
+	}
+	return ""
 }
 
 type Ollama struct {
-	endpoint  string
-	modelName string
+	endpoint        string
+	modelName       string
+	maxRequestBytes int
+	// headers are added to every request, e.g. auth for a gateway in front
+	// of the endpoint
+	headers http.Header
+	// MaxRetries is how many times a request is retried after a connection
+	// error or a 5xx response, with exponential backoff. It defaults to
+	// OLLAMA_MAX_RETRIES, or defaultMaxRetries.
+	MaxRetries int
+	// client is reused across requests so connections to the endpoint are
+	// pooled; it has no Timeout, the request context bounds each call.
+	client *http.Client
 }
 
+const (
+	defaultMaxRetries = 2
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 10 * time.Second
+)
+
 func NewOllama(endpoint, modelName string) *Ollama {
+	maxRequestBytes := defaultMaxRequestBytes
+	if n, err := strconv.Atoi(os.Getenv("OLLAMA_MAX_REQUEST_BYTES")); err == nil && n > 0 {
+		maxRequestBytes = n
+	}
+	maxRetries := defaultMaxRetries
+	if n, err := strconv.Atoi(os.Getenv("OLLAMA_MAX_RETRIES")); err == nil && n >= 0 {
+		maxRetries = n
+	}
 	return &Ollama{
-		endpoint:  endpoint,
-		modelName: modelName,
+		endpoint:        endpoint,
+		modelName:       modelName,
+		maxRequestBytes: maxRequestBytes,
+		headers:         headersFromEnv(os.Getenv("OLLAMA_HEADERS")),
+		MaxRetries:      maxRetries,
+		client:          &http.Client{Transport: outboundTransport},
+	}
+}
+
+// SetHeader adds a header sent with every request, replacing any value set
+// before, including one from OLLAMA_HEADERS.
+func (o *Ollama) SetHeader(key, value string) {
+	o.headers.Set(key, value)
+}
+
+// headersFromEnv parses "Name: value" pairs separated by semicolons, e.g.
+// "Authorization: Bearer abc; X-Org-Id: 42". Malformed entries are skipped
+// with a warning.
+func headersFromEnv(s string) http.Header {
+	h := http.Header{}
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "warning: ignoring malformed OLLAMA_HEADERS entry %q\n", redactHeaderEntry(entry))
+			continue
+		}
+		h.Set(key, strings.TrimSpace(value))
+	}
+	return h
+}
+
+// sensitiveHeader reports whether a header's value must not be logged.
+func sensitiveHeader(key string) bool {
+	key = strings.ToLower(key)
+	switch key {
+	case "authorization", "proxy-authorization", "cookie":
+		return true
 	}
+	for _, word := range []string{"token", "key", "secret", "auth", "password"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders formats h for logging with sensitive values hidden.
+func redactHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := strings.Join(h[k], ", ")
+		if sensitiveHeader(k) {
+			value = "[redacted]"
+		}
+		parts = append(parts, k+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// redactHeaderEntry hides everything after the first few characters of a
+// malformed entry, which may still contain a secret.
+func redactHeaderEntry(entry string) string {
+	entry = strings.TrimSpace(entry)
+	if len(entry) > 4 {
+		return entry[:4] + "..."
+	}
+	return entry
 }
 
 func (o *Ollama) sendChatRequest(ctx context.Context, reqBody ProviderRequest) (ProviderResponse, error) {
@@ -50,30 +230,71 @@ func (o *Ollama) sendChatRequest(ctx context.Context, reqBody ProviderRequest) (
 	if err != nil {
 		return ProviderResponse{}, fmt.Errorf("marshal request: %w", err)
 	}
+	if len(payload) > o.maxRequestBytes {
+		return ProviderResponse{}, fmt.Errorf("%w: %d bytes (limit %d)", ErrRequestTooLarge, len(payload), o.maxRequestBytes)
+	}
+
+	for attempt := 1; ; attempt++ {
+		chatResp, retryable, err := o.post(ctx, payload)
+		if err == nil || !retryable || attempt > o.MaxRetries || ctx.Err() != nil {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return chatResp, err
+		}
+		delay := retryDelay(attempt)
+		debugf("ollama request failed (attempt %d): %v; retrying in %s", attempt, err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ProviderResponse{}, fmt.Errorf("%w (after %d attempts)", err, attempt)
+		case <-timer.C:
+		}
+	}
+}
 
-	httpClient := &http.Client{Timeout: 0} // rely on context timeout
+// retryDelay doubles from retryBaseDelay with each attempt, up to
+// retryMaxDelay, and adds up to 50% jitter so clients don't retry in step.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt <= 16 {
+		delay = min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	}
+	return delay + rand.N(delay/2+1)
+}
+
+// post sends one request. Connection errors and 5xx responses are reported
+// as retryable; anything else, like a 400 or an undecodable body, is not.
+func (o *Ollama) post(ctx context.Context, payload []byte) (ProviderResponse, bool, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(payload))
 	if err != nil {
-		return ProviderResponse{}, fmt.Errorf("create request: %w", err)
+		return ProviderResponse{}, false, fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range o.headers {
+		req.Header[k] = v
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if len(o.headers) > 0 {
+		debugf("ollama request headers: %s", redactHeaders(o.headers))
+	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := o.client.Do(req)
 	if err != nil {
-		return ProviderResponse{}, fmt.Errorf("request ollama: %w", err)
+		return ProviderResponse{}, true, fmt.Errorf("request ollama: %w", err)
 	}
 	body, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		return ProviderResponse{}, fmt.Errorf("read response: %w", err)
+		return ProviderResponse{}, true, fmt.Errorf("read response: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return ProviderResponse{}, fmt.Errorf("ollama error: status %d, body: %s", resp.StatusCode, string(body))
+		return ProviderResponse{}, resp.StatusCode >= 500, &ProviderError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var chatResp ProviderResponse
 	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return ProviderResponse{}, fmt.Errorf("decode response: %w; body: %s", err, string(body))
+		return ProviderResponse{}, false, fmt.Errorf("decode response: %w; body: %s", err, string(body))
 	}
-	return chatResp, nil
+	return chatResp, false, nil
 }
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModelErrorReason(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusNotFound, `{"error":"model \"llama3\" not found, try pulling it first"}`, "model not found"},
+		{http.StatusNotFound, `404 page not found`, ""},
+		{http.StatusInternalServerError, `{"error":"model requires more system memory (9.1 GiB) than is available (4.0 GiB)"}`, "out of memory"},
+		{http.StatusInternalServerError, `{"error":"llama runner process has terminated: cudaMalloc failed: out of memory"}`, "out of memory"},
+		// A 500 that merely mentions the model is not a model error
+		{http.StatusInternalServerError, `{"error":"model runner crashed"}`, ""},
+		{http.StatusInternalServerError, `{"error":"failed to write memory profile"}`, ""},
+		{http.StatusServiceUnavailable, `{"error":"server busy, please try again"}`, "server overloaded"},
+		{http.StatusTooManyRequests, ``, "server overloaded"},
+		{http.StatusBadRequest, `{"error":"model is required"}`, ""},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("send: %w", &ProviderError{StatusCode: tt.status, Body: tt.body})
+		if got := modelErrorReason(err); got != tt.want {
+			t.Errorf("modelErrorReason(%d %s) = %q, want %q", tt.status, tt.body, got, tt.want)
+		}
+	}
+	if got := modelErrorReason(errors.New("connection refused")); got != "" {
+		t.Errorf("modelErrorReason(connection error) = %q, want \"\"", got)
+	}
+}
+
+// modelProvider fails for the models in errs and answers for any other,
+// with toolCall when it is set and the request offers tools.
+type modelProvider struct {
+	errs     map[string]error
+	toolCall *ToolCall
+	models   []string
+}
+
+func (p *modelProvider) sendChatRequest(_ context.Context, req ProviderRequest) (ProviderResponse, error) {
+	p.models = append(p.models, req.Model)
+	if err := p.errs[req.Model]; err != nil {
+		return ProviderResponse{}, err
+	}
+	if p.toolCall != nil && len(req.Tools) > 0 {
+		return toolRound(p.toolCall), nil
+	}
+	resp := ProviderResponse{}
+	resp.Message = AgentMessage{Role: "assistant", Content: "hi from " + req.Model}
+	return resp, nil
+}
+
+func TestFallbackLogsReason(t *testing.T) {
+	var errOut bytes.Buffer
+	provider := &modelProvider{errs: map[string]error{
+		"": &ProviderError{StatusCode: http.StatusInternalServerError, Body: `{"error":"model requires more system memory (9 GiB) than is available (4 GiB)"}`},
+	}}
+	agent := &Agent{ErrOut: &errOut, FallbackModels: []string{"small"}, session: &Session{}}
+	reply, err := agent.runInference(context.Background(), []UserMessage{{Role: "user", Content: "hello"}}, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "hi from small" {
+		t.Errorf("reply = %q, want the fallback model's", reply.Content)
+	}
+	if got := errOut.String(); !strings.Contains(got, "the primary model failed (out of memory)") || !strings.Contains(got, "falling back to model small") {
+		t.Errorf("log = %q", got)
+	}
+}
+
+func TestStepLimitSummaryUsesFallback(t *testing.T) {
+	withRegistry(t, echoTool{"echo"})
+	provider := &modelProvider{
+		errs:     map[string]error{"": &ProviderError{StatusCode: http.StatusTooManyRequests}},
+		toolCall: call("echo", map[string]any{"text": "x"}),
+	}
+	agent := &Agent{
+		ErrOut:          &bytes.Buffer{},
+		FallbackModels:  []string{"small"},
+		MaxSteps:        1,
+		StepLimitPrompt: DefaultStepLimitPrompt,
+		session:         &Session{},
+	}
+	reply, err := agent.runInference(context.Background(), []UserMessage{{Role: "user", Content: "hello"}}, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "hi from small" {
+		t.Errorf("reply = %q, want the fallback model's summary", reply.Content)
+	}
+	if want := []string{"", "small", "small"}; strings.Join(provider.models, ",") != strings.Join(want, ",") {
+		t.Errorf("models asked = %q, want %q", provider.models, want)
+	}
+}
+
+func TestNoFallbackForOtherErrors(t *testing.T) {
+	provider := &modelProvider{errs: map[string]error{
+		"": &ProviderError{StatusCode: http.StatusInternalServerError, Body: `{"error":"model runner crashed"}`},
+	}}
+	agent := &Agent{ErrOut: &bytes.Buffer{}, FallbackModels: []string{"small"}, session: &Session{}}
+	if _, err := agent.runInference(context.Background(), []UserMessage{{Role: "user", Content: "hello"}}, provider); err == nil {
+		t.Fatal("runInference succeeded")
+	}
+	if len(provider.models) != 1 {
+		t.Errorf("models tried = %q, want only the primary", provider.models)
+	}
+}
+
+func TestHeadersFromEnv(t *testing.T) {
+	h := headersFromEnv("Authorization: Bearer abc; X-Org-Id: 42 ;; malformed; X-Empty:")
+	want := map[string]string{"Authorization": "Bearer abc", "X-Org-Id": "42", "X-Empty": ""}
+	if len(h) != len(want) {
+		t.Errorf("headersFromEnv() = %v, want %v", h, want)
+	}
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("header %s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+// headerServer answers chat requests and records the headers of the last.
+func headerServer(t *testing.T) (*httptest.Server, *http.Header) {
+	t.Helper()
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"message":{"role":"assistant","content":"ok"},"done":true}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+func TestOllamaSendsExtraHeaders(t *testing.T) {
+	t.Setenv("OLLAMA_HEADERS", "Authorization: Bearer from-env; X-Org-Id: 42; Content-Type: text/plain")
+	srv, got := headerServer(t)
+	o := NewOllama(srv.URL+"/api/chat", "m")
+	o.SetHeader("Authorization", "Bearer override")
+	o.SetHeader("X-Trace", "abc")
+	if _, err := o.sendChatRequest(context.Background(), ProviderRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Authorization": "Bearer override",
+		"X-Org-Id":      "42",
+		"X-Trace":       "abc",
+		// The request body is always JSON
+		"Content-Type": "application/json",
+	}
+	for k, v := range want {
+		if g := got.Get(k); g != v {
+			t.Errorf("server got %s = %q, want %q", k, g, v)
+		}
+	}
+}
+
+func TestOllamaHeadersRedactedInDebugOutput(t *testing.T) {
+	var out bytes.Buffer
+	savedEnabled, savedOut := debugEnabled, debugOut
+	debugEnabled, debugOut = true, &out
+	defer func() { debugEnabled, debugOut = savedEnabled, savedOut }()
+
+	srv, got := headerServer(t)
+	o := NewOllama(srv.URL+"/api/chat", "m")
+	o.SetHeader("Authorization", "Bearer s3cret")
+	o.SetHeader("X-Api-Key", "k3y")
+	o.SetHeader("X-Org-Id", "42")
+	if _, err := o.sendChatRequest(context.Background(), ProviderRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "Bearer s3cret" {
+		t.Errorf("server got Authorization %q; redaction must only affect the log", got.Get("Authorization"))
+	}
+	log := out.String()
+	for _, want := range []string{"Authorization: [redacted]", "X-Api-Key: [redacted]", "X-Org-Id: 42"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("debug output %q does not contain %q", log, want)
+		}
+	}
+	for _, secret := range []string{"s3cret", "k3y"} {
+		if strings.Contains(log, secret) {
+			t.Errorf("debug output leaks %q: %q", secret, log)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// maxRandomBytes bounds random_bytes output.
+const maxRandomBytes = 1024
+
+// randomValue produces a value for the random tool. Every mode draws from
+// crypto/rand, so the results are fit for IDs and secrets.
+func randomValue(args map[string]any) (string, error) {
+	mode, _ := args["mode"].(string)
+	switch mode = strings.TrimPrefix(mode, "random_"); mode {
+	case "int":
+		lo, okLo := numberArg(args, "min")
+		hi, okHi := numberArg(args, "max")
+		if !okLo || !okHi {
+			return "", fmt.Errorf("int mode requires integer min and max")
+		}
+		if lo > hi {
+			return "", fmt.Errorf("min must not be greater than max")
+		}
+		span := new(big.Int).Sub(big.NewInt(hi), big.NewInt(lo))
+		n, err := rand.Int(rand.Reader, span.Add(span, big.NewInt(1)))
+		if err != nil {
+			return "", fmt.Errorf("read random: %w", err)
+		}
+		return n.Add(n, big.NewInt(lo)).String(), nil
+	case "uuid":
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", fmt.Errorf("read random: %w", err)
+		}
+		b[6] = b[6]&0x0f | 0x40 // version 4
+		b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+	case "bytes":
+		n := intArg(args, "n", 16)
+		if n > maxRandomBytes {
+			return "", fmt.Errorf("n must be at most %d", maxRandomBytes)
+		}
+		b := make([]byte, n)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("read random: %w", err)
+		}
+		switch encoding, _ := args["encoding"].(string); encoding {
+		case "", "hex":
+			return hex.EncodeToString(b), nil
+		case "base64":
+			return base64.StdEncoding.EncodeToString(b), nil
+		default:
+			return "", fmt.Errorf("encoding must be hex or base64")
+		}
+	case "choice":
+		options, _ := args["options"].([]any)
+		if len(options) == 0 {
+			return "", fmt.Errorf("choice mode requires a non-empty options list")
+		}
+		i, err := rand.Int(rand.Reader, big.NewInt(int64(len(options))))
+		if err != nil {
+			return "", fmt.Errorf("read random: %w", err)
+		}
+		return fmt.Sprint(options[i.Int64()]), nil
+	case "":
+		return "", fmt.Errorf("missing required argument: mode")
+	default:
+		return "", fmt.Errorf("unknown mode %q: use int, uuid, bytes or choice", mode)
+	}
+}
+
+// numberArg reads an integer argument that may be negative or zero, unlike
+// intArg. It reports false when the argument is missing or not a whole
+// number.
+func numberArg(args map[string]any, key string) (int64, bool) {
+	switch t := args[key].(type) {
+	case float64:
+		if t != math.Trunc(t) || math.Abs(t) > 1<<53 {
+			return 0, false
+		}
+		return int64(t), true
+	case int:
+		return int64(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestRandomInt(t *testing.T) {
+	tests := []struct{ lo, hi int64 }{
+		{0, 2},
+		{-5, -3},
+		{7, 7},
+		{-1 << 40, 1 << 40},
+	}
+	for _, tt := range tests {
+		seen := map[int64]bool{}
+		for i := 0; i < 200; i++ {
+			got, err := randomValue(map[string]any{"mode": "int", "min": float64(tt.lo), "max": float64(tt.hi)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := strconv.ParseInt(got, 10, 64)
+			if err != nil || n < tt.lo || n > tt.hi {
+				t.Fatalf("int in [%d, %d] = %q", tt.lo, tt.hi, got)
+			}
+			seen[n] = true
+		}
+		// Small ranges are covered end to end, bounds included
+		if tt.hi-tt.lo < 3 && int64(len(seen)) != tt.hi-tt.lo+1 {
+			t.Errorf("int in [%d, %d] only produced %v", tt.lo, tt.hi, seen)
+		}
+	}
+}
+
+func TestRandomFormats(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for i := 0; i < 20; i++ {
+		got, err := randomValue(map[string]any{"mode": "random_uuid"})
+		if err != nil || !uuid.MatchString(got) {
+			t.Fatalf("uuid = %q, %v", got, err)
+		}
+	}
+
+	tests := []struct {
+		args   map[string]any
+		decode func(string) ([]byte, error)
+		n      int
+	}{
+		{map[string]any{"mode": "bytes"}, hex.DecodeString, 16},
+		{map[string]any{"mode": "bytes", "n": float64(5), "encoding": "hex"}, hex.DecodeString, 5},
+		{map[string]any{"mode": "bytes", "n": float64(maxRandomBytes), "encoding": "base64"}, base64.StdEncoding.DecodeString, maxRandomBytes},
+	}
+	for _, tt := range tests {
+		got, err := randomValue(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := tt.decode(got); err != nil || len(b) != tt.n {
+			t.Errorf("bytes %v = %q: %d bytes, %v; want %d", tt.args, got, len(b), err, tt.n)
+		}
+	}
+
+	options := []any{"red", "green", float64(3)}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		got, err := randomValue(map[string]any{"mode": "choice", "options": options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[got] = true
+	}
+	if len(seen) != 3 || !seen["red"] || !seen["green"] || !seen["3"] {
+		t.Errorf("choices made = %v, want each of red, green and 3", seen)
+	}
+}
+
+func TestRandomErrors(t *testing.T) {
+	tests := []map[string]any{
+		{},
+		{"mode": "float"},
+		{"mode": "int", "min": float64(1)},
+		{"mode": "int", "min": float64(5), "max": float64(1)},
+		{"mode": "int", "min": 0.5, "max": float64(1)},
+		{"mode": "bytes", "n": float64(maxRandomBytes + 1)},
+		{"mode": "bytes", "encoding": "base32"},
+		{"mode": "choice"},
+		{"mode": "choice", "options": []any{}},
+	}
+	for _, args := range tests {
+		if got, err := randomValue(args); err == nil {
+			t.Errorf("randomValue(%v) = %q, want an error", args, got)
+		}
+	}
+}
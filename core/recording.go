@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// recordedExchange is one line of a recording file.
+type recordedExchange struct {
+	Hash     string           `json:"hash"`
+	Request  ProviderRequest  `json:"request"`
+	Response ProviderResponse `json:"response"`
+}
+
+// RecordingProvider either records every exchange with the wrapped provider
+// to a JSON lines file, or replays a recording without touching the network.
+// Replayed responses are looked up by a hash of the request; identical
+// requests get their recorded responses in order.
+type RecordingProvider struct {
+	inner Provider
+
+	mu     sync.Mutex
+	file   *os.File
+	replay map[string][]ProviderResponse
+}
+
+// NewRecordingProvider records the exchanges of inner to path, appending to
+// the file if it exists.
+func NewRecordingProvider(inner Provider, path string) (*RecordingProvider, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	return &RecordingProvider{inner: inner, file: f}, nil
+}
+
+// NewReplayProvider serves the responses recorded in path.
+func NewReplayProvider(path string) (*RecordingProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+	p := &RecordingProvider{replay: map[string][]ProviderResponse{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var ex recordedExchange
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("recording line %d: %w", line, err)
+		}
+		p.replay[ex.Hash] = append(p.replay[ex.Hash], ex.Response)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	return p, nil
+}
+
+func (p *RecordingProvider) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	return p.file.Close()
+}
+
+// ListModels asks the wrapped provider; a replay has no server to ask.
+func (p *RecordingProvider) ListModels(ctx context.Context) ([]string, error) {
+	if lister, ok := p.inner.(ModelLister); ok {
+		return lister.ListModels(ctx)
+	}
+	return nil, errors.ErrUnsupported
+}
+
+func (p *RecordingProvider) sendChatRequest(ctx context.Context, reqBody ProviderRequest) (ProviderResponse, error) {
+	hash, err := requestHash(reqBody)
+	if err != nil {
+		return ProviderResponse{}, err
+	}
+	if p.replay != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		queue := p.replay[hash]
+		if len(queue) == 0 {
+			return ProviderResponse{}, fmt.Errorf("replay: no recorded response for request %s", hash[:12])
+		}
+		p.replay[hash] = queue[1:]
+		return queue[0], nil
+	}
+	resp, err := p.inner.sendChatRequest(ctx, reqBody)
+	if err != nil {
+		return resp, err
+	}
+	line, err := json.Marshal(recordedExchange{Hash: hash, Request: reqBody, Response: resp})
+	if err != nil {
+		return resp, fmt.Errorf("marshal recording: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return resp, fmt.Errorf("write recording: %w", err)
+	}
+	return resp, nil
+}
+
+func requestHash(reqBody ProviderRequest) (string, error) {
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func reply(content string) ProviderResponse {
+	resp := ProviderResponse{}
+	resp.Message = AgentMessage{Role: "assistant", Content: content}
+	return resp
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	ask := func(content string) ProviderRequest {
+		return ProviderRequest{Model: "m", Messages: []UserMessage{{Role: "user", Content: content}}}
+	}
+	ctx := context.Background()
+
+	inner := &scriptProvider{replies: []ProviderResponse{reply("first"), reply("second"), reply("other")}}
+	rec, err := NewRecordingProvider(inner, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"hello", "hello", "bye"} {
+		if _, err := rec.sendChatRequest(ctx, ask(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A failed exchange is not recorded
+	if _, err := rec.sendChatRequest(ctx, ask("unanswered")); err == nil {
+		t.Fatal("the inner provider had no replies left")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	// Identical requests get their responses in the order recorded
+	for _, tt := range []struct{ content, want string }{
+		{"bye", "other"},
+		{"hello", "first"},
+		{"hello", "second"},
+	} {
+		resp, err := replay.sendChatRequest(ctx, ask(tt.content))
+		if err != nil || resp.Message.Content != tt.want {
+			t.Errorf("replay %q = %q, %v; want %q", tt.content, resp.Message.Content, err, tt.want)
+		}
+	}
+	for _, content := range []string{"hello", "unanswered", "never asked"} {
+		if _, err := replay.sendChatRequest(ctx, ask(content)); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+			t.Errorf("replay %q: err = %v, want no recorded response", content, err)
+		}
+	}
+}
+
+func TestReplayMalformedRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.jsonl")
+	if err := os.WriteFile(path, []byte("{\"hash\":\"x\"}\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReplayProvider(path); err == nil || !strings.Contains(err.Error(), "recording line 2") {
+		t.Errorf("err = %v, want the bad line reported", err)
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	maxRegexTestText    = 64 << 10 // 64KB
+	maxRegexTestMatches = 100
+)
+
+// RegexMatch is one match found by regex_test. Start and End are byte
+// offsets into the sample text; Groups holds the capture groups in order,
+// and Named those with a name.
+type RegexMatch struct {
+	Match  string            `json:"match"`
+	Start  int               `json:"start"`
+	End    int               `json:"end"`
+	Groups []string          `json:"groups,omitempty"`
+	Named  map[string]string `json:"named,omitempty"`
+}
+
+// regexTest compiles pattern with Go's regexp syntax and returns its matches
+// in text as JSON, or the compile error.
+func regexTest(pattern, text string) (string, error) {
+	if len(text) > maxRegexTestText {
+		return "", fmt.Errorf("text too large: %d bytes (limit %d)", len(text), maxRegexTestText)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	names := re.SubexpNames()
+	locs := re.FindAllStringSubmatchIndex(text, maxRegexTestMatches+1)
+	truncated := len(locs) > maxRegexTestMatches
+	if truncated {
+		locs = locs[:maxRegexTestMatches]
+	}
+	matches := make([]RegexMatch, 0, len(locs))
+	for _, loc := range locs {
+		m := RegexMatch{Match: text[loc[0]:loc[1]], Start: loc[0], End: loc[1]}
+		for g := 1; g < len(names); g++ {
+			value := ""
+			if loc[2*g] >= 0 {
+				value = text[loc[2*g]:loc[2*g+1]]
+			}
+			m.Groups = append(m.Groups, value)
+			if names[g] != "" {
+				if m.Named == nil {
+					m.Named = map[string]string{}
+				}
+				m.Named[names[g]] = value
+			}
+		}
+		matches = append(matches, m)
+	}
+	out, err := json.MarshalIndent(map[string]any{
+		"count":   len(matches),
+		"matches": matches,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal matches: %w", err)
+	}
+	result := string(out)
+	if truncated {
+		result += fmt.Sprintf("\n... only the first %d matches are shown ...", maxRegexTestMatches)
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// Tool is something the model can call. Implement it and register it on
+// DefaultRegistry to offer a new tool without changing this package.
+type Tool interface {
+	Name() string
+	Definition() ToolDef
+	Execute(ctx context.Context, args map[string]any) (string, error)
+}
+
+// Registry holds tools by name. Definitions are listed in the order the
+// tools were first registered, so the prompt stays stable between runs.
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	order []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]Tool{}}
+}
+
+// Register adds t, replacing any tool registered under the same name.
+func (r *Registry) Register(t Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := t.Name()
+	if _, ok := r.tools[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.tools[name] = t
+}
+
+// Lookup returns the tool registered under name.
+func (r *Registry) Lookup(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Definitions returns the definitions of all registered tools.
+func (r *Registry) Definitions() []ToolDef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]ToolDef, 0, len(r.order))
+	for _, name := range r.order {
+		defs = append(defs, r.tools[name].Definition())
+	}
+	return defs
+}
+
+// Tools returns the registered tools in registration order.
+func (r *Registry) Tools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tools := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tools = append(tools, r.tools[name])
+	}
+	return tools
+}
+
+// DefaultRegistry holds the tools offered to the model, starting with the
+// built-in ones. Agent-level tools such as the plan tools are not in it;
+// each agent adds them to its own registry.
+var DefaultRegistry = newBuiltinRegistry()
+
+func newBuiltinRegistry() *Registry {
+	r := NewRegistry()
+	for _, t := range builtinTools() {
+		r.Register(t)
+	}
+	return r
+}
+
+// builtinTool is a tool that ships with the agent: its definition and the
+// function that runs it.
+type builtinTool struct {
+	def ToolDef
+	run func(ctx context.Context, args map[string]any) (string, error)
+}
+
+func (b builtinTool) Name() string {
+	return b.def.Function.Name
+}
+
+func (b builtinTool) Definition() ToolDef {
+	return b.def
+}
+
+func (b builtinTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	return b.run(ctx, args)
+}
+
+// agentTool is a tool that works on the agent itself, such as the plan
+// tools. Unlike other tools its results are never shortened by
+// ToolResultLimit, which read_tool_result depends on.
+type agentTool struct {
+	builtinTool
+}
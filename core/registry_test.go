@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type echoTool struct{ name string }
+
+func (e echoTool) Name() string { return e.name }
+
+func (e echoTool) Definition() ToolDef {
+	return ToolDef{Type: "function", Function: FunctionDef{Name: e.name}}
+}
+
+func (e echoTool) Execute(_ context.Context, args map[string]any) (string, error) {
+	text, _ := args["text"].(string)
+	return e.name + ": " + text, nil
+}
+
+// withRegistry replaces DefaultRegistry with one holding only tools for the
+// rest of the test.
+func withRegistry(t *testing.T, tools ...Tool) {
+	t.Helper()
+	saved := DefaultRegistry
+	DefaultRegistry = NewRegistry()
+	for _, tool := range tools {
+		DefaultRegistry.Register(tool)
+	}
+	t.Cleanup(func() { DefaultRegistry = saved })
+}
+
+func call(name string, args map[string]any) *ToolCall {
+	tc := &ToolCall{}
+	tc.Function.Name = name
+	tc.Function.Arguments = args
+	return tc
+}
+
+func TestAgentDispatchesThroughRegistry(t *testing.T) {
+	// An agent-level tool wins over a registered tool of the same name
+	withRegistry(t, echoTool{"echo"}, echoTool{"plan_show"})
+
+	agent := &Agent{session: &Session{}}
+	tests := []struct {
+		name, want, wantErr string
+	}{
+		{"echo", "echo: hi", ""},
+		{"plan_show", "no plan", ""},
+		{"missing", "", "unknown tool: missing"},
+	}
+	for _, tt := range tests {
+		got, err := agent.dispatchTool(context.Background(), call(tt.name, map[string]any{"text": "hi"}))
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestDispatchCompactsOnlyRegisteredTools(t *testing.T) {
+	withRegistry(t, echoTool{"echo"})
+
+	agent := &Agent{session: &Session{}, ToolResultLimit: 20}
+	long := strings.Repeat("x", 100)
+	got, err := agent.dispatchTool(context.Background(), call("echo", map[string]any{"text": long}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "read_tool_result") {
+		t.Fatalf("long result was not shortened: %q", got)
+	}
+	page, err := agent.dispatchTool(context.Background(), call("read_tool_result", map[string]any{"handle": "result-1", "length": float64(200)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page, "result shortened") || !strings.HasSuffix(page, long) {
+		t.Errorf("read_tool_result was shortened again: %q", page)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+// defaultMaxResponseBytes is used unless KUTAGENT_MAX_RESPONSE_BYTES is set.
+const defaultMaxResponseBytes = 256 << 10 // 256KB
+
+func maxResponseBytesFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("KUTAGENT_MAX_RESPONSE_BYTES")); err == nil && n >= 0 {
+		return n
+	}
+	return defaultMaxResponseBytes
+}
+
+// requestOptions returns the model options for a request. When
+// MaxResponseBytes is set and no num_predict was chosen, generation is
+// bounded at the source too; a token is rarely shorter than two bytes, so
+// this only stops replies well past the cap.
+func (agent *Agent) requestOptions() map[string]any {
+	if agent.MaxResponseBytes <= 0 {
+		if len(agent.Options) == 0 {
+			return nil
+		}
+		return agent.Options
+	}
+	if _, ok := agent.Options["num_predict"]; ok {
+		return agent.Options
+	}
+	opts := make(map[string]any, len(agent.Options)+1)
+	for k, v := range agent.Options {
+		opts[k] = v
+	}
+	opts["num_predict"] = agent.MaxResponseBytes / 2
+	return opts
+}
+
+// capResponse truncates an assistant reply longer than MaxResponseBytes
+// before it is stored in the history, so one runaway generation can't eat
+// the context of every following request.
+func (agent *Agent) capResponse(msg UserMessage) UserMessage {
+	limit := agent.MaxResponseBytes
+	if limit <= 0 || len(msg.Content) <= limit {
+		return msg
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(msg.Content[cut]) {
+		cut--
+	}
+	fmt.Fprintf(agent.ErrOut, "warning: model response of %d bytes truncated to %d in the history\n", len(msg.Content), cut)
+	msg.Content = msg.Content[:cut] + fmt.Sprintf("\n... [response truncated from %d bytes] ...", len(msg.Content))
+	return msg
+}
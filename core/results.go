@@ -0,0 +1,146 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	maxStoredResults     = 32
+	maxStoredResultBytes = 16 << 20 // 16MB
+)
+
+// resultStore keeps the full text of tool results that were too large to put
+// in the conversation, so the model can page through them on demand. Once
+// it holds more than maxStoredResults results or maxStoredResultBytes bytes,
+// the least recently used are dropped; the newest is always kept.
+type resultStore struct {
+	results map[string]string
+	order   []string // least recently used first
+	bytes   int
+	next    int
+}
+
+func (s *resultStore) put(result string) string {
+	if s.results == nil {
+		s.results = map[string]string{}
+	}
+	s.next++
+	handle := fmt.Sprintf("result-%d", s.next)
+	s.results[handle] = result
+	s.order = append(s.order, handle)
+	s.bytes += len(result)
+	for len(s.order) > 1 && (len(s.order) > maxStoredResults || s.bytes > maxStoredResultBytes) {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		s.bytes -= len(s.results[oldest])
+		delete(s.results, oldest)
+	}
+	return handle
+}
+
+// get returns the result stored under handle and marks it recently used.
+func (s *resultStore) get(handle string) (string, bool) {
+	result, ok := s.results[handle]
+	if !ok {
+		return "", false
+	}
+	for i, h := range s.order {
+		if h == handle {
+			s.order = append(append(s.order[:i:i], s.order[i+1:]...), handle)
+			break
+		}
+	}
+	return result, true
+}
+
+func readToolResultDef() ToolDef {
+	return ToolDef{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "read_tool_result",
+			Description: "Read part of a large tool result that was shortened in the conversation. Input: { handle: string, offset?: integer, length?: integer }",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"handle": map[string]any{"type": "string"},
+					"offset": map[string]any{"type": "integer"},
+					"length": map[string]any{"type": "integer"},
+				},
+				"required":             []string{"handle"},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
+
+// compactResult replaces a result longer than ToolResultLimit with a
+// preview and a handle for read_tool_result.
+func (agent *Agent) compactResult(result string) string {
+	limit := agent.ToolResultLimit
+	if limit <= 0 || len(result) <= limit {
+		return result
+	}
+	handle := agent.results.put(result)
+	preview := cutAtRune(result, limit)
+	return fmt.Sprintf("%s\n... result shortened: showing %d of %d bytes. Call read_tool_result with handle %q and an offset to read more ...",
+		preview, len(preview), len(result), handle)
+}
+
+func (agent *Agent) readToolResult(_ context.Context, args map[string]any) (string, error) {
+	handle, _ := args["handle"].(string)
+	full, ok := agent.results.get(handle)
+	if !ok {
+		return "", fmt.Errorf("unknown result handle: %q (only the %d most recently used results are kept)", handle, maxStoredResults)
+	}
+	offset := 0
+	if v, ok := args["offset"].(float64); ok && v > 0 {
+		offset = int(v)
+	}
+	if offset >= len(full) {
+		return "", fmt.Errorf("offset %d is past the end of the result (%d bytes)", offset, len(full))
+	}
+	// Pages start and end on rune boundaries, and hold at least one rune
+	for offset > 0 && !utf8.RuneStart(full[offset]) {
+		offset--
+	}
+	page := cutAtRune(full[offset:], intArg(args, "length", agent.ToolResultLimit))
+	if page == "" {
+		_, size := utf8.DecodeRuneInString(full[offset:])
+		page = full[offset : offset+size]
+	}
+	return fmt.Sprintf("bytes %d-%d of %d\n%s", offset, offset+len(page), len(full), page), nil
+}
+
+// minDuplicateBytes is the smallest result worth replacing with a reference.
+const minDuplicateBytes = 256
+
+// compactDuplicates replaces earlier tool results that are identical to one
+// of the newest results with a short reference, keeping only the latest
+// copy. The replaced messages keep their tool_call_id and name, so every
+// call still has its answer. messages is copied before it is changed since
+// its start is shared with the session history.
+func compactDuplicates(messages []UserMessage, newest int) []UserMessage {
+	copied := false
+	// Newest first, so duplicates within the latest round also point to
+	// its last copy
+	for j := len(messages) - 1; j >= newest; j-- {
+		latest := messages[j]
+		if latest.Role != "tool" || len(latest.Content) < minDuplicateBytes {
+			continue
+		}
+		for i := 0; i < j; i++ {
+			m := messages[i]
+			if m.Role != "tool" || m.Name != latest.Name || m.Content != latest.Content {
+				continue
+			}
+			if !copied {
+				messages = cloneMessages(messages)
+				copied = true
+			}
+			messages[i].Content = fmt.Sprintf("[identical to a later result of %s (tool_call_id=%s), see below]", latest.Name, latest.ToolCallID)
+		}
+	}
+	return messages
+}
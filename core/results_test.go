@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestResultStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	var s resultStore
+	first := s.put("first")
+	second := s.put("second")
+	for i := 0; i < maxStoredResults-2; i++ {
+		s.put(fmt.Sprint(i))
+	}
+	// Reading first makes second the least recently used
+	if _, ok := s.get(first); !ok {
+		t.Fatal("first result missing before the store is full")
+	}
+	s.put("one too many")
+	if _, ok := s.get(second); ok {
+		t.Error("least recently used result was kept")
+	}
+	if _, ok := s.get(first); !ok {
+		t.Error("recently read result was evicted")
+	}
+	if len(s.results) != maxStoredResults {
+		t.Errorf("store holds %d results, want %d", len(s.results), maxStoredResults)
+	}
+}
+
+func TestResultStoreByteCap(t *testing.T) {
+	var s resultStore
+	half := strings.Repeat("x", maxStoredResultBytes/2)
+	a := s.put(half)
+	s.put(half)
+	s.put("x")
+	if _, ok := s.get(a); ok {
+		t.Error("store kept more than maxStoredResultBytes")
+	}
+	// A single result over the cap is still kept
+	big := s.put(strings.Repeat("y", maxStoredResultBytes+1))
+	if _, ok := s.get(big); !ok || len(s.results) != 1 {
+		t.Errorf("oversized newest result: kept=%v, %d results stored", ok, len(s.results))
+	}
+}
+
+func TestCompactResultCutsAtRune(t *testing.T) {
+	agent := &Agent{ToolResultLimit: 4}
+	got := agent.compactResult("aaé" + strings.Repeat("b", 10)) // é is 2 bytes at offsets 2-3
+	preview, _, _ := strings.Cut(got, "\n")
+	if !utf8.ValidString(got) || preview != "aaé" {
+		t.Errorf("compactResult() preview = %q", preview)
+	}
+	agent.ToolResultLimit = 3
+	got = agent.compactResult("aaé" + strings.Repeat("b", 10))
+	if preview, _, _ := strings.Cut(got, "\n"); preview != "aa" || !strings.Contains(got, "showing 2 of") {
+		t.Errorf("compactResult() = %q, want a 2 byte preview", got)
+	}
+}
+
+func TestReadToolResultPagesAtRunes(t *testing.T) {
+	agent := &Agent{ToolResultLimit: 3}
+	full := "aé€b"
+	handle := agent.results.put(full)
+	tests := []struct {
+		offset, length int
+		want           string
+	}{
+		{0, 3, "bytes 0-3 of 7\naé"},
+		{0, 2, "bytes 0-1 of 7\na"},
+		// Offsets inside a rune move back to its start
+		{2, 3, "bytes 1-3 of 7\né"},
+		// A page always holds at least one rune
+		{3, 1, "bytes 3-6 of 7\n€"},
+		{6, 10, "bytes 6-7 of 7\nb"},
+	}
+	for _, tt := range tests {
+		got, err := agent.readToolResult(context.Background(), map[string]any{
+			"handle": handle, "offset": float64(tt.offset), "length": float64(tt.length),
+		})
+		if err != nil || got != tt.want {
+			t.Errorf("read offset %d length %d = %q, %v; want %q", tt.offset, tt.length, got, err, tt.want)
+		}
+	}
+}
+
+func TestCompactDuplicatesRepeatedReads(t *testing.T) {
+	root, _ := newRoot(t)
+	content := strings.Repeat("package main\n", 40) // over minDuplicateBytes
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{session: &Session{}, root: root, CompactDuplicates: true, caps: defaultCapabilities}
+	read := func(id string) *ToolCall {
+		return withID(call("read_file", map[string]any{"path": "main.go"}), id)
+	}
+
+	var messages []UserMessage
+	messages, _ = agent.runTools(context.Background(), 1, toolRound(read("r1"), withID(call("time_now", nil), "t1")), messages)
+	messages, _ = agent.runTools(context.Background(), 2, toolRound(read("r2"), read("r3")), messages)
+
+	// Every call still has exactly one answer with its id
+	answers := map[string]string{}
+	for _, m := range messages {
+		if m.Role == "tool" {
+			if _, dup := answers[m.ToolCallID]; dup {
+				t.Errorf("tool_call_id %s answered twice", m.ToolCallID)
+			}
+			answers[m.ToolCallID] = m.Content
+		}
+	}
+	if len(answers) != 4 {
+		t.Fatalf("answers = %v, want one for each of the 4 calls", answers)
+	}
+	// Only the newest copy keeps the content; the others point to it
+	ref := "[identical to a later result of read_file (tool_call_id=r3), see below]"
+	for id, want := range map[string]string{"r1": ref, "r2": ref, "r3": content} {
+		if answers[id] != want {
+			t.Errorf("answer to %s = %q, want %q", id, answers[id], want)
+		}
+	}
+	if answers["t1"] == ref {
+		t.Error("an unrelated result was compacted")
+	}
+}
+
+func TestCompactDuplicatesKeepsHistory(t *testing.T) {
+	long := strings.Repeat("x", minDuplicateBytes)
+	history := []UserMessage{
+		{Role: "tool", Content: long, ToolCallID: "a", Name: "read_file"},
+		{Role: "tool", Content: "short", ToolCallID: "b", Name: "read_file"},
+		{Role: "tool", Content: long, ToolCallID: "c", Name: "search_files"},
+	}
+	messages := append(history[:len(history):len(history)],
+		UserMessage{Role: "tool", Content: long, ToolCallID: "d", Name: "read_file"},
+		UserMessage{Role: "tool", Content: "short", ToolCallID: "e", Name: "read_file"},
+	)
+	got := compactDuplicates(messages, len(history))
+	if history[0].Content != long {
+		t.Error("compactDuplicates changed the shared history")
+	}
+	if !strings.Contains(got[0].Content, "tool_call_id=d") || got[0].ToolCallID != "a" {
+		t.Errorf("first read = %+v, want a reference to d that keeps id a", got[0])
+	}
+	// Results under minDuplicateBytes and other tools' results are kept
+	if got[1].Content != "short" || got[2].Content != long {
+		t.Errorf("compacted a short result or another tool's: %+v", got[1:3])
+	}
+}
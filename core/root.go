@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type projectRootKey struct{}
+
+// withProjectRoot makes root the project root for the tools run with ctx.
+func withProjectRoot(ctx context.Context, root string) context.Context {
+	return context.WithValue(ctx, projectRootKey{}, root)
+}
+
+// projectRoot returns the project root the file tools are confined to: the
+// one set on ctx, or else the working directory.
+func projectRoot(ctx context.Context) (string, error) {
+	if root, ok := ctx.Value(projectRootKey{}).(string); ok && root != "" {
+		return root, nil
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("getwd: %w", err)
+	}
+	return root, nil
+}
+
+// resolveProjectRoot turns dir, or the working directory when dir is empty,
+// into an absolute path with symlinks resolved, so the containment checks
+// compare against the real location.
+func resolveProjectRoot(dir string) (string, error) {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("getwd: %w", err)
+		}
+		dir = wd
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("project root: %w", err)
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("project root: %w", err)
+	}
+	fi, err := os.Stat(real)
+	if err != nil {
+		return "", fmt.Errorf("project root: %w", err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("project root %s is not a directory", dir)
+	}
+	return real, nil
+}
+
+// commandDir returns the directory a shell command runs in: root, or cwd
+// relative to it. An absolute cwd is accepted only if it lies inside root.
+func commandDir(root, cwd string) (string, error) {
+	if cwd == "" {
+		return root, nil
+	}
+	if filepath.IsAbs(cwd) {
+		rel, err := filepath.Rel(root, cwd)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", errOutsideRoot
+		}
+		cwd = rel
+	}
+	dir, err := resolveWithinRoot(root, cwd)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("cwd: %w", err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("cwd %s is not a directory", cwd)
+	}
+	return dir, nil
+}
@@ -0,0 +1,309 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
+
+// SearchBackend is implemented by anything that can answer a web search query.
+type SearchBackend interface {
+	Search(ctx context.Context, query string, maxResults int) ([]SearchResult, error)
+}
+
+// NewSearchBackendFromEnv picks a backend based on KUTAGENT_SEARCH_BACKEND
+// (searxng, brave or duckduckgo). DuckDuckGo is used when nothing is set.
+func NewSearchBackendFromEnv() (SearchBackend, error) {
+	backend := strings.ToLower(os.Getenv("KUTAGENT_SEARCH_BACKEND"))
+	switch backend {
+	case "", "duckduckgo", "ddg":
+		return &DuckDuckGoSearch{endpoint: "https://html.duckduckgo.com/html/"}, nil
+	case "searxng":
+		endpoint := os.Getenv("SEARXNG_URL")
+		if endpoint == "" {
+			return nil, fmt.Errorf("SEARXNG_URL must be set for the searxng backend")
+		}
+		return &SearxngSearch{endpoint: strings.TrimRight(endpoint, "/") + "/search"}, nil
+	case "brave":
+		apiKey := os.Getenv("BRAVE_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("BRAVE_API_KEY must be set for the brave backend")
+		}
+		return &BraveSearch{endpoint: "https://api.search.brave.com/res/v1/web/search", apiKey: apiKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown search backend: %s", backend)
+	}
+}
+
+type searchBackendKey struct{}
+
+// withSearchBackend makes b answer web_search for the tools run with ctx.
+func withSearchBackend(ctx context.Context, b SearchBackend) context.Context {
+	return context.WithValue(ctx, searchBackendKey{}, b)
+}
+
+// searchBackendFrom returns the backend set on ctx, or else the one
+// configured in the environment.
+func searchBackendFrom(ctx context.Context) (SearchBackend, error) {
+	if b, ok := ctx.Value(searchBackendKey{}).(SearchBackend); ok {
+		return b, nil
+	}
+	return NewSearchBackendFromEnv()
+}
+
+// searchLimiter spaces out outgoing search queries so we don't hammer the backend.
+var searchLimiter = newRateLimiter(time.Second)
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	return &rateLimiter{interval: interval}
+}
+
+// Wait blocks until the next slot is free or ctx is done.
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	slot := r.next
+	if slot.Before(now) {
+		slot = now
+	}
+	r.next = slot.Add(r.interval)
+	r.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func webSearch(ctx context.Context, backend SearchBackend, query string, maxResults int) (string, error) {
+	if err := searchLimiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("rate limit wait: %w", err)
+	}
+	results, err := backend.Search(ctx, query, maxResults)
+	if err != nil {
+		return "", err
+	}
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal results: %w", err)
+	}
+	return string(out), nil
+}
+
+func searchGet(ctx context.Context, reqURL string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "KutAgent/1.0 (+https://example.com)")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := toolHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("search request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	const maxBytes = 1 << 20 // 1MB
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read search response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search error: status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+type SearxngSearch struct {
+	endpoint string
+}
+
+func (s *SearxngSearch) Search(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+	q := url.Values{"q": {query}, "format": {"json"}}
+	body, err := searchGet(ctx, s.endpoint+"?"+q.Encode(), http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("decode searxng response: %w", err)
+	}
+	results := make([]SearchResult, 0, maxResults)
+	for _, r := range parsed.Results {
+		if len(results) >= maxResults {
+			break
+		}
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+	return results, nil
+}
+
+type BraveSearch struct {
+	endpoint string
+	apiKey   string
+}
+
+func (s *BraveSearch) Search(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+	q := url.Values{"q": {query}, "count": {fmt.Sprint(maxResults)}}
+	header := http.Header{
+		"Accept":               {"application/json"},
+		"X-Subscription-Token": {s.apiKey},
+	}
+	body, err := searchGet(ctx, s.endpoint+"?"+q.Encode(), header)
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("decode brave response: %w", err)
+	}
+	results := make([]SearchResult, 0, maxResults)
+	for _, r := range parsed.Web.Results {
+		if len(results) >= maxResults {
+			break
+		}
+		results = append(results, SearchResult{
+			Title:   r.Title,
+			URL:     r.URL,
+			Snippet: normalizeWS(stripTagsQuick(r.Description)),
+		})
+	}
+	return results, nil
+}
+
+type DuckDuckGoSearch struct {
+	endpoint string
+}
+
+func (s *DuckDuckGoSearch) Search(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+	q := url.Values{"q": {query}}
+	body, err := searchGet(ctx, s.endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("parse duckduckgo response: %w", err)
+	}
+	results := make([]SearchResult, 0, maxResults)
+	var walk func(*html.Node)
+	walk = func(nd *html.Node) {
+		if len(results) >= maxResults {
+			return
+		}
+		if nd.Type == html.ElementNode && nd.Data == "a" {
+			switch {
+			case hasClass(nd, "result__a"):
+				results = append(results, SearchResult{
+					Title: normalizeWS(nodeText(nd)),
+					URL:   ddgTargetURL(attr(nd, "href")),
+				})
+				return
+			case hasClass(nd, "result__snippet") && len(results) > 0:
+				results[len(results)-1].Snippet = normalizeWS(nodeText(nd))
+				return
+			}
+		}
+		for c := nd.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return results, nil
+}
+
+// ddgTargetURL unwraps DuckDuckGo's redirect links (//duckduckgo.com/l/?uddg=...).
+func ddgTargetURL(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	if target := u.Query().Get("uddg"); target != "" {
+		return target
+	}
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+func attr(nd *html.Node, key string) string {
+	for _, a := range nd.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(nd *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(nd, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeText(nd *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(nd)
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// fakeSearch returns n numbered results whatever maxResults asks for, and
+// records the queries it was sent.
+type fakeSearch struct {
+	n       int
+	queries []string
+}
+
+func (f *fakeSearch) Search(_ context.Context, query string, _ int) ([]SearchResult, error) {
+	f.queries = append(f.queries, query)
+	results := make([]SearchResult, f.n)
+	for i := range results {
+		results[i] = SearchResult{
+			Title:   fmt.Sprintf("result %d", i+1),
+			URL:     fmt.Sprintf("https://example.com/%d", i+1),
+			Snippet: "about " + query,
+		}
+	}
+	return results, nil
+}
+
+func TestWebSearchTruncates(t *testing.T) {
+	limiter := searchLimiter
+	searchLimiter = newRateLimiter(0)
+	t.Cleanup(func() { searchLimiter = limiter })
+
+	tests := []struct{ n, maxResults, want int }{
+		{5, 2, 2},
+		{1, 3, 1},
+		{0, 5, 0},
+	}
+	for _, tt := range tests {
+		out, err := webSearch(context.Background(), &fakeSearch{n: tt.n}, "gophers", tt.maxResults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []map[string]string
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("output is not a JSON array: %v\n%s", err, out)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%d results with max %d: got %d, want %d", tt.n, tt.maxResults, len(got), tt.want)
+		}
+		for i, r := range got {
+			want := map[string]string{
+				"title":   fmt.Sprintf("result %d", i+1),
+				"url":     fmt.Sprintf("https://example.com/%d", i+1),
+				"snippet": "about gophers",
+			}
+			if len(r) != len(want) || r["title"] != want["title"] || r["url"] != want["url"] || r["snippet"] != want["snippet"] {
+				t.Errorf("result %d = %v, want %v", i, r, want)
+			}
+		}
+	}
+}
+
+func TestAgentSearchBackend(t *testing.T) {
+	limiter := searchLimiter
+	searchLimiter = newRateLimiter(0)
+	t.Cleanup(func() { searchLimiter = limiter })
+	// An unknown backend in the environment would fail the lookup
+	t.Setenv("KUTAGENT_SEARCH_BACKEND", "nonexistent")
+
+	backend := &fakeSearch{n: 30}
+	agent := &Agent{SearchBackend: backend, session: &Session{}}
+	out, err := agent.runTool(context.Background(), call("web_search", map[string]any{"query": "gophers", "max_results": float64(50)}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []SearchResult
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	// max_results is capped at 20
+	if len(got) != 20 || len(backend.queries) != 1 || backend.queries[0] != "gophers" {
+		t.Errorf("got %d results for queries %q, want 20 for gophers", len(got), backend.queries)
+	}
+
+	agent.SearchBackend = nil
+	if _, err := agent.runTool(context.Background(), call("web_search", map[string]any{"query": "gophers"}), false); err == nil {
+		t.Error("without Agent.SearchBackend the environment's backend should be used")
+	}
+}
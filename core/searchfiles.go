@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxSearchMatches  = 1000
+	maxSearchOutput   = 1 << 20  // 1MB
+	maxSearchFileSize = 10 << 20 // 10MB
+	maxSearchLineLen  = 300
+	// binarySniffLen is how much of a file is checked for NUL bytes
+	binarySniffLen = 8 << 10 // 8KB
+)
+
+// errSearchLimit stops the walk once enough matches have been collected.
+var errSearchLimit = errors.New("search limit reached")
+
+// searchFiles looks for pattern in the files under dir, or in dir itself if
+// it is a file, and returns the matching lines as path:line: text with
+// paths relative to root. Without useRegex the pattern is a literal
+// substring. .git, .gitignored entries and binary files are skipped.
+func searchFiles(root, dir, pattern string, useRegex bool) (string, error) {
+	match := func(line string) bool { return strings.Contains(line, pattern) }
+	if useRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern: %w", err)
+		}
+		match = re.MatchString
+	}
+	gi := loadGitignore(root)
+
+	var b strings.Builder
+	matches := 0
+	search := func(path, rel string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		r := bufio.NewReaderSize(f, binarySniffLen)
+		if head, _ := r.Peek(binarySniffLen); bytes.IndexByte(head, 0) >= 0 {
+			return nil
+		}
+		for lineNo := 1; ; lineNo++ {
+			line, err := r.ReadString('\n')
+			if text := strings.TrimRight(line, "\r\n"); line != "" && match(text) {
+				if runes := []rune(text); len(runes) > maxSearchLineLen {
+					text = string(runes[:maxSearchLineLen]) + "..."
+				}
+				fmt.Fprintf(&b, "%s:%d: %s\n", rel, lineNo, text)
+				matches++
+				if matches >= maxSearchMatches || b.Len() > maxSearchOutput {
+					return errSearchLimit
+				}
+			}
+			if err != nil {
+				// io.EOF, or a read error that ends this file only
+				return nil
+			}
+		}
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if path != dir && (d.Name() == ".git" || gi.Match(rel, d.IsDir())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		// Ensure still under root (defense in depth)
+		if !withinDir(root, path) {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxSearchFileSize {
+			return nil
+		}
+		return search(path, rel)
+	})
+	if err != nil && !errors.Is(err, errSearchLimit) {
+		return "", fmt.Errorf("walk dir: %w", err)
+	}
+	if matches == 0 {
+		return "no matches", nil
+	}
+	out := b.String()
+	if errors.Is(err, errSearchLimit) {
+		out += fmt.Sprintf("... stopped after %d matches; narrow the pattern or path ...\n", matches)
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
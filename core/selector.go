@@ -0,0 +1,316 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxSelectorMatches caps how many elements fetch_selector returns.
+const maxSelectorMatches = 50
+
+// A selector is a comma-separated group of chains such as "div.item > a".
+// Only the common subset of CSS is supported: type, #id, .class and
+// [attr], [attr=value], [attr~=value], [attr^=value], [attr$=value],
+// [attr*=value], combined with descendant (space) and child (>)
+// combinators.
+type selector []selectorChain
+
+// selectorChain matches compounds[len-1] on the element itself and the
+// earlier compounds on its ancestors; child[i] means compounds[i] must be
+// the direct parent of compounds[i+1].
+type selectorChain struct {
+	compounds []compoundSelector
+	child     []bool
+}
+
+type compoundSelector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	key, op, value string
+}
+
+func parseSelector(s string) (selector, error) {
+	var sel selector
+	for _, group := range strings.Split(s, ",") {
+		chain, err := parseChain(group)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, chain)
+	}
+	return sel, nil
+}
+
+func parseChain(s string) (selectorChain, error) {
+	var chain selectorChain
+	// Pad ">" so it becomes a token of its own
+	fields := strings.Fields(strings.ReplaceAll(s, ">", " > "))
+	if len(fields) == 0 {
+		return chain, fmt.Errorf("empty selector")
+	}
+	child := false
+	for _, f := range fields {
+		if f == ">" {
+			if len(chain.compounds) == 0 || child {
+				return chain, fmt.Errorf("misplaced '>' in selector %q", strings.TrimSpace(s))
+			}
+			child = true
+			continue
+		}
+		c, err := parseCompound(f)
+		if err != nil {
+			return chain, err
+		}
+		if len(chain.compounds) > 0 {
+			chain.child = append(chain.child, child)
+		}
+		chain.compounds = append(chain.compounds, c)
+		child = false
+	}
+	if child {
+		return chain, fmt.Errorf("selector %q ends with '>'", strings.TrimSpace(s))
+	}
+	return chain, nil
+}
+
+func parseCompound(s string) (compoundSelector, error) {
+	var c compoundSelector
+	i := 0
+	for i < len(s) && !strings.ContainsRune("#.[:", rune(s[i])) {
+		i++
+	}
+	if tag := s[:i]; tag != "*" {
+		c.tag = strings.ToLower(tag)
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '#', '.':
+			j := i + 1
+			for j < len(s) && !strings.ContainsRune("#.[:", rune(s[j])) {
+				j++
+			}
+			name := s[i+1 : j]
+			if name == "" {
+				return c, fmt.Errorf("empty name in selector %q", s)
+			}
+			if s[i] == '#' {
+				c.id = name
+			} else {
+				c.classes = append(c.classes, name)
+			}
+			i = j
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return c, fmt.Errorf("unclosed '[' in selector %q", s)
+			}
+			a, err := parseAttrSelector(s[i+1 : i+end])
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, a)
+			i += end + 1
+		default:
+			return c, fmt.Errorf("unsupported selector syntax in %q", s)
+		}
+	}
+	return c, nil
+}
+
+func parseAttrSelector(s string) (attrSelector, error) {
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		if s == "" {
+			return attrSelector{}, fmt.Errorf("empty attribute selector")
+		}
+		return attrSelector{key: strings.ToLower(s)}, nil
+	}
+	key, op := s[:eq], "="
+	if eq > 0 && strings.ContainsRune("~^$*", rune(s[eq-1])) {
+		key, op = s[:eq-1], s[eq-1:eq+1]
+	}
+	value := strings.Trim(s[eq+1:], `"'`)
+	return attrSelector{key: strings.ToLower(key), op: op, value: value}, nil
+}
+
+func (c compoundSelector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !hasClass(n, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) matches(n *html.Node) bool {
+	for _, at := range n.Attr {
+		if at.Key != a.key {
+			continue
+		}
+		switch a.op {
+		case "":
+			return true
+		case "=":
+			return at.Val == a.value
+		case "~=":
+			for _, f := range strings.Fields(at.Val) {
+				if f == a.value {
+					return true
+				}
+			}
+			return false
+		case "^=":
+			return strings.HasPrefix(at.Val, a.value)
+		case "$=":
+			return strings.HasSuffix(at.Val, a.value)
+		case "*=":
+			return strings.Contains(at.Val, a.value)
+		}
+	}
+	return false
+}
+
+// matchAt reports whether compounds[:i+1] match with compounds[i] on n.
+func (ch selectorChain) matchAt(n *html.Node, i int) bool {
+	if !ch.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if ch.child[i-1] {
+		return n.Parent != nil && ch.matchAt(n.Parent, i-1)
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if ch.matchAt(p, i-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sel selector) matches(n *html.Node) bool {
+	for _, ch := range sel {
+		if ch.matchAt(n, len(ch.compounds)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectorMatch is one element found by fetch_selector.
+type SelectorMatch struct {
+	Tag   string            `json:"tag"`
+	Text  string            `json:"text"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// selectElements returns the elements of doc matching sel in document
+// order, at most limit of them, and the total number of matches.
+func selectElements(doc *html.Node, sel selector, withAttrs bool, limit int) ([]SelectorMatch, int) {
+	var matches []SelectorMatch
+	total := 0
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if sel.matches(n) {
+			total++
+			if len(matches) < limit {
+				m := SelectorMatch{Tag: n.Data, Text: normalizeWS(nodeText(n))}
+				if withAttrs && len(n.Attr) > 0 {
+					m.Attrs = map[string]string{}
+					for _, a := range n.Attr {
+						m.Attrs[a.Key] = a.Val
+					}
+				}
+				matches = append(matches, m)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return matches, total
+}
+
+// fetchSelector downloads the page at rawURL and returns the elements that
+// match the CSS selector as JSON.
+func fetchSelector(ctx context.Context, rawURL, selectorText string, withAttrs bool, timeout time.Duration) (string, error) {
+	sel, err := parseSelector(selectorText)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/html,*/*")
+	req.Header.Set("User-Agent", "KutAgent/1.0 (+https://example.com)")
+	resp, err := toolHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s: status %d", rawURL, resp.StatusCode)
+	}
+	const maxBytes = 1 << 20 // 1MB
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		return "", fmt.Errorf("read body: %w", err)
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("parse html: %w", err)
+	}
+	matches, total := selectElements(doc, sel, withAttrs, maxSelectorMatches)
+	if total == 0 {
+		return fmt.Sprintf("no elements match %q", selectorText), nil
+	}
+	out, err := json.MarshalIndent(matches, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal matches: %w", err)
+	}
+	result := string(out)
+	if total > len(matches) {
+		result += fmt.Sprintf("\n... %d more matches omitted ...", total-len(matches))
+	}
+	return result, nil
+}
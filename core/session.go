@@ -0,0 +1,193 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultBranch = "main"
+
+// Session holds the conversation history of the active branch along with
+// any named branches forked from it.
+type Session struct {
+	Messages []UserMessage
+	// Plan is the model's checklist for the current task, shared by all
+	// branches.
+	Plan Plan
+	// Bookmarks are locations worth coming back to; unlike the plan they
+	// survive a reset.
+	Bookmarks Bookmarks
+	// Usage is the running total of tokens and model time spent on the
+	// conversation, across branches and resets.
+	Usage    Usage
+	current  string
+	branches map[string][]UserMessage
+	meta     Metadata
+	id       string
+}
+
+// Metadata describes a conversation so saved sessions can be organized and
+// browsed. It is never sent to the model.
+type Metadata struct {
+	Title   string    `json:"title,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// maxAutoTitle bounds a title derived from the first user message.
+const maxAutoTitle = 60
+
+func NewSession() *Session {
+	now := Now()
+	return &Session{
+		current:  defaultBranch,
+		branches: map[string][]UserMessage{},
+		meta:     Metadata{Created: now, Updated: now},
+		id:       newSessionID(),
+	}
+}
+
+// ID identifies the session, e.g. to correlate its metrics and logs.
+func (s *Session) ID() string {
+	return s.id
+}
+
+func newSessionID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Metadata returns the session's metadata. Without an explicit title, the
+// first line of the first user message is used.
+func (s *Session) Metadata() Metadata {
+	meta := s.meta
+	meta.Tags = append([]string(nil), s.meta.Tags...)
+	if meta.Title == "" {
+		meta.Title = autoTitle(s.Messages)
+	}
+	return meta
+}
+
+// SetTitle sets the title; an empty title goes back to the automatic one.
+func (s *Session) SetTitle(title string) {
+	s.meta.Title = strings.TrimSpace(title)
+	s.touch()
+}
+
+// AddTag tags the session. Tags are kept sorted and unique.
+func (s *Session) AddTag(tag string) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || s.HasTag(tag) {
+		return
+	}
+	s.meta.Tags = append(s.meta.Tags, tag)
+	sort.Strings(s.meta.Tags)
+	s.touch()
+}
+
+// RemoveTag removes tag if the session has it.
+func (s *Session) RemoveTag(tag string) {
+	for i, t := range s.meta.Tags {
+		if t == tag {
+			s.meta.Tags = append(s.meta.Tags[:i], s.meta.Tags[i+1:]...)
+			s.touch()
+			return
+		}
+	}
+}
+
+// HasTag reports whether the session is tagged with tag.
+func (s *Session) HasTag(tag string) bool {
+	for _, t := range s.meta.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// touch records that the session changed.
+func (s *Session) touch() {
+	s.meta.Updated = Now()
+}
+
+func autoTitle(msgs []UserMessage) string {
+	for _, m := range msgs {
+		if m.Role != "user" {
+			continue
+		}
+		title, _, _ := strings.Cut(strings.TrimSpace(m.Content), "\n")
+		if r := []rune(title); len(r) > maxAutoTitle {
+			title = string(r[:maxAutoTitle]) + "..."
+		}
+		return title
+	}
+	return ""
+}
+
+// Current returns the name of the active branch.
+func (s *Session) Current() string {
+	return s.current
+}
+
+// Branches returns the names of all known branches, sorted.
+func (s *Session) Branches() []string {
+	names := []string{s.current}
+	for name := range s.branches {
+		if name != s.current {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reset clears the history of the active branch and the plan, which is
+// shared by all branches.
+func (s *Session) Reset() {
+	s.Messages = nil
+	s.Plan = nil
+}
+
+// Fork snapshots the active history into a new branch and switches to it.
+// The branch that was active keeps its history untouched.
+func (s *Session) Fork(name string) error {
+	if name == "" {
+		return fmt.Errorf("branch name must not be empty")
+	}
+	if _, ok := s.branches[name]; ok || name == s.current {
+		return fmt.Errorf("branch %q already exists", name)
+	}
+	s.branches[s.current] = cloneMessages(s.Messages)
+	s.current = name
+	s.Messages = cloneMessages(s.Messages)
+	return nil
+}
+
+// Switch makes the named branch active, saving the current one first.
+func (s *Session) Switch(name string) error {
+	if name == s.current {
+		return nil
+	}
+	msgs, ok := s.branches[name]
+	if !ok {
+		return fmt.Errorf("unknown branch %q", name)
+	}
+	s.branches[s.current] = cloneMessages(s.Messages)
+	delete(s.branches, name)
+	s.current = name
+	s.Messages = cloneMessages(msgs)
+	return nil
+}
+
+func cloneMessages(msgs []UserMessage) []UserMessage {
+	return append([]UserMessage(nil), msgs...)
+}
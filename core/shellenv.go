@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type cleanShellEnvKey struct{}
+
+// withCleanShellEnv makes run_shell start from an empty environment instead
+// of the agent's own for the tools run with ctx.
+func withCleanShellEnv(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cleanShellEnvKey{}, true)
+}
+
+// parseEnvArg reads run_shell's env argument, an object of variable names
+// to values. Numbers and booleans are accepted as their text.
+func parseEnvArg(v any) (map[string]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("env must be an object of variable names to string values")
+	}
+	env := make(map[string]string, len(m))
+	for k, val := range m {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return nil, fmt.Errorf("invalid environment variable name %q", k)
+		}
+		switch val := val.(type) {
+		case string:
+			if strings.ContainsRune(val, 0) {
+				return nil, fmt.Errorf("environment variable %s contains a NUL byte", k)
+			}
+			env[k] = val
+		case float64, bool:
+			env[k] = fmt.Sprint(val)
+		default:
+			return nil, fmt.Errorf("environment variable %s must be a string", k)
+		}
+	}
+	return env, nil
+}
+
+// commandEnv builds the environment for a shell command: the agent's own,
+// or none when replace is set or ctx asks for a clean environment, with
+// overrides applied on top. A nil result means the command simply inherits
+// the agent's environment.
+func commandEnv(ctx context.Context, overrides map[string]string, replace bool) []string {
+	clean, _ := ctx.Value(cleanShellEnvKey{}).(bool)
+	if len(overrides) == 0 && !replace && !clean {
+		return nil
+	}
+	env := []string{}
+	if !replace && !clean {
+		// exec keeps the last value of a repeated variable, so the
+		// overrides appended below win
+		env = os.Environ()
+	}
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+overrides[name])
+	}
+	// An empty, non-nil Env gives the command no variables at all
+	return env
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// maxShellFileOutput bounds how much run_shell_to_file writes, so a runaway
+// command can't fill the disk.
+const maxShellFileOutput = 100 << 20 // 100MB
+
+// cappedWriter writes up to limit bytes to w and silently drops the rest,
+// so the command keeps running instead of failing on a broken pipe.
+type cappedWriter struct {
+	w       *os.File
+	limit   int64
+	written int64
+	dropped bool
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	room := c.limit - c.written
+	if room <= 0 {
+		c.dropped = true
+		return len(p), nil
+	}
+	chunk := p
+	if int64(len(chunk)) > room {
+		chunk = chunk[:room]
+		c.dropped = true
+	}
+	n, err := c.w.Write(chunk)
+	c.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
+
+// runShellToFile runs cmdStr via sh -c with its combined output going to
+// outputPath inside root, and reports the exit code and bytes written
+// instead of the output itself.
+func runShellToFile(ctx context.Context, root, cmdStr, outputPath string, timeoutSec int) (string, error) {
+	abs, err := resolveWithinRoot(root, outputPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		return "", fmt.Errorf("create output directory: %w", err)
+	}
+	f, err := os.Create(abs)
+	if err != nil {
+		return "", fmt.Errorf("create output file: %w", err)
+	}
+	defer f.Close()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, "sh", "-c", cmdStr)
+	cmd.Dir = root
+	cmd.WaitDelay = 2 * time.Second
+	out := &cappedWriter{w: f, limit: maxShellFileOutput}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	exitCode := 0
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			exitCode = ee.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+	result := fmt.Sprintf("exit_code=%d bytes_written=%d path=%s", exitCode, out.written, outputPath)
+	if out.dropped {
+		result += fmt.Sprintf("\n... output beyond %d bytes was dropped ...", int64(maxShellFileOutput))
+	}
+	if errors.Is(cctx.Err(), context.DeadlineExceeded) {
+		result += fmt.Sprintf("\n... command timed out after %d seconds ...", timeoutSec)
+	}
+	return result, nil
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxSnapshotFiles = 5000
+	// Files up to this size keep their contents so session_diff can show
+	// line diffs for them, up to maxSnapshotContentTotal in all.
+	maxSnapshotContent      = 256 << 10 // 256KB
+	maxSnapshotContentTotal = 32 << 20  // 32MB
+	// Larger files are not read at all; they are compared by size and
+	// modification time.
+	maxSnapshotHash = 64 << 20 // 64MB
+)
+
+type snapshotEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string  // empty for files over maxSnapshotHash
+	content *string // only in the session start snapshot
+}
+
+// sameAs reports whether e and other describe the same file contents.
+func (e snapshotEntry) sameAs(other snapshotEntry) bool {
+	if e.size != other.size {
+		return false
+	}
+	if e.hash != "" && other.hash != "" {
+		return e.hash == other.hash
+	}
+	return e.modTime.Equal(other.modTime)
+}
+
+// projectSnapshot records the state of the project's files, keyed by their
+// slash-separated path relative to the root.
+type projectSnapshot struct {
+	root         string
+	files        map[string]snapshotEntry
+	contentBytes int
+	truncated    bool
+}
+
+// takeSnapshot hashes the files under root, skipping .git and anything
+// matched by .gitignore, and keeps the contents of small text files.
+func takeSnapshot(root string) (*projectSnapshot, error) {
+	return scanProject(root, nil, true)
+}
+
+// scanProject walks root like takeSnapshot. Files whose size and
+// modification time match their entry in prev reuse its hash instead of
+// being read again.
+func scanProject(root string, prev *projectSnapshot, keepContent bool) (*projectSnapshot, error) {
+	gi := loadGitignore(root)
+	snap := &projectSnapshot{root: root, files: map[string]snapshotEntry{}}
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.Name() == ".git" || gi.Match(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if len(snap.files) >= maxSnapshotFiles {
+			snap.truncated = true
+			return filepath.SkipAll
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entry := snapshotEntry{size: info.Size(), modTime: info.ModTime()}
+		if old, ok := prev.lookup(rel); ok && old.size == entry.size && old.modTime.Equal(entry.modTime) {
+			entry.hash = old.hash
+			snap.files[rel] = entry
+			return nil
+		}
+		if entry.size > maxSnapshotHash {
+			snap.files[rel] = entry
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		entry.hash = hex.EncodeToString(sum[:])
+		if keepContent && len(data) <= maxSnapshotContent && snap.contentBytes+len(data) <= maxSnapshotContentTotal && utf8.Valid(data) {
+			text := string(data)
+			entry.content = &text
+			snap.contentBytes += len(data)
+		}
+		snap.files[rel] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("snapshot project: %w", err)
+	}
+	return snap, nil
+}
+
+func (snap *projectSnapshot) lookup(rel string) (snapshotEntry, bool) {
+	if snap == nil {
+		return snapshotEntry{}, false
+	}
+	e, ok := snap.files[rel]
+	return e, ok
+}
+
+// currentText reads the file at rel now, or returns nil if it is binary
+// or too large to diff.
+func (snap *projectSnapshot) currentText(rel string) *string {
+	path := filepath.Join(snap.root, filepath.FromSlash(rel))
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxSnapshotContent {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) > maxSnapshotContent || !utf8.Valid(data) {
+		return nil
+	}
+	text := string(data)
+	return &text
+}
+
+// diffSince compares the project now against the snapshot and lists the
+// created, modified and deleted files, optionally with line diffs.
+func (snap *projectSnapshot) diffSince(withDiffs bool) (string, error) {
+	now, err := scanProject(snap.root, snap, false)
+	if err != nil {
+		return "", err
+	}
+	var created, modified, deleted []string
+	for path, entry := range now.files {
+		before, ok := snap.files[path]
+		if !ok {
+			created = append(created, path)
+		} else if !before.sameAs(entry) {
+			modified = append(modified, path)
+		}
+	}
+	for path := range snap.files {
+		if _, ok := now.files[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	if len(created)+len(modified)+len(deleted) == 0 {
+		return "no changes since the session started", nil
+	}
+	sort.Strings(created)
+	sort.Strings(modified)
+	sort.Strings(deleted)
+
+	var b strings.Builder
+	for _, group := range []struct {
+		label string
+		paths []string
+	}{{"created", created}, {"modified", modified}, {"deleted", deleted}} {
+		for _, p := range group.paths {
+			fmt.Fprintf(&b, "%s %s\n", group.label, p)
+		}
+	}
+	if snap.truncated || now.truncated {
+		fmt.Fprintf(&b, "... only the first %d files are tracked ...\n", maxSnapshotFiles)
+	}
+	if withDiffs {
+		for _, p := range modified {
+			before, after := snap.files[p].content, snap.currentText(p)
+			if before == nil || after == nil {
+				fmt.Fprintf(&b, "\n%s: binary or too large to diff\n", p)
+				continue
+			}
+			b.WriteString("\n" + unifiedDiff("a/"+p, "b/"+p, *before, *after))
+		}
+		for _, p := range created {
+			if after := snap.currentText(p); after != nil {
+				b.WriteString("\n" + unifiedDiff("/dev/null", "b/"+p, "", *after))
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func sessionDiffDef() ToolDef {
+	return ToolDef{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "session_diff",
+			Description: "List the files created, modified or deleted in the project since this session started, optionally with line diffs. Input: { diffs?: boolean }",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"diffs": map[string]any{"type": "boolean"},
+				},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
+
+func (agent *Agent) sessionDiffTool(ctx context.Context, args map[string]any) (string, error) {
+	withDiffs, _ := args["diffs"].(bool)
+	return agent.sessionDiff(ctx, withDiffs)
+}
+
+func (agent *Agent) sessionDiff(_ context.Context, withDiffs bool) (string, error) {
+	if agent.snapshot == nil {
+		return "", fmt.Errorf("no snapshot of the project was taken at session start")
+	}
+	return agent.snapshot.diffSince(withDiffs)
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("keep.txt", "same\n")
+	write("edit.txt", "one\ntwo\n")
+	write("gone.txt", "bye\n")
+	snap, err := takeSnapshot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("edit.txt", "one\n2\n")
+	write("new.txt", "hello\n")
+	if err := os.Remove(filepath.Join(root, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := snap.diffSince(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"created new.txt", "modified edit.txt", "deleted gone.txt", "-two", "+2", "+hello"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diffSince() = %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "keep.txt") {
+		t.Errorf("diffSince() lists the unchanged keep.txt: %q", got)
+	}
+}
+
+func TestSnapshotLargeFile(t *testing.T) {
+	root := t.TempDir()
+	big := filepath.Join(root, "big.bin")
+	// A sparse file, so the test doesn't write 64MB
+	if err := os.WriteFile(big, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(big, maxSnapshotHash+1); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := takeSnapshot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := snap.files["big.bin"]
+	if entry.hash != "" || entry.content != nil || entry.size != maxSnapshotHash+1 {
+		t.Errorf("entry for a file over the cap = %+v, want size only", entry)
+	}
+	if got, _ := snap.diffSince(false); got != "no changes since the session started" {
+		t.Errorf("diffSince() = %q, want no changes", got)
+	}
+
+	later := entry.modTime.Add(time.Minute)
+	if err := os.Chtimes(big, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := snap.diffSince(true); !strings.Contains(got, "modified big.bin") || !strings.Contains(got, "binary or too large to diff") {
+		t.Errorf("diffSince() after touching the file = %q", got)
+	}
+}
+
+func TestSnapshotEntrySameAs(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	tests := []struct {
+		a, b snapshotEntry
+		want bool
+	}{
+		{snapshotEntry{size: 1, hash: "x"}, snapshotEntry{size: 1, hash: "x"}, true},
+		{snapshotEntry{size: 1, hash: "x"}, snapshotEntry{size: 1, hash: "y"}, false},
+		{snapshotEntry{size: 1, hash: "x"}, snapshotEntry{size: 2, hash: "x"}, false},
+		// Without hashes the modification time decides
+		{snapshotEntry{size: 1, modTime: t0}, snapshotEntry{size: 1, modTime: t0}, true},
+		{snapshotEntry{size: 1, modTime: t0}, snapshotEntry{size: 1, modTime: t0.Add(time.Second)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.sameAs(tt.b); got != tt.want {
+			t.Errorf("%+v.sameAs(%+v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
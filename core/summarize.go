@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// summarizeChunkBytes is the size of each piece of the file sent to the
+	// model; chunks end at a line break where possible.
+	summarizeChunkBytes = 32 << 10 // 32KB
+	// maxSummarizeChunks bounds the auxiliary calls per file: one per chunk
+	// plus one to combine the chunk summaries.
+	maxSummarizeChunks = 8
+)
+
+func summarizeFileDef() ToolDef {
+	return ToolDef{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "summarize_file",
+			Description: "Summarize a file that is too large to read whole. The file is split into chunks that are summarized separately and then combined; only the first 256KB are covered. Pass focus to summarize with a topic in mind. Input: { path: string, focus?: string }",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path":  map[string]any{"type": "string"},
+					"focus": map[string]any{"type": "string"},
+				},
+				"required":             []string{"path"},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
+
+// summarizeFile summarizes the file at path with auxiliary provider calls
+// that don't touch the conversation.
+func (agent *Agent) summarizeFile(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	focus, _ := args["focus"].(string)
+	if agent.provider == nil {
+		return "", fmt.Errorf("summarize_file is only available during a turn")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	abs, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	if looksBinary(data) {
+		return "", fmt.Errorf("file appears to be binary or non-UTF-8; %d bytes", len(data))
+	}
+	chunks, truncated := splitChunks(string(data), summarizeChunkBytes, maxSummarizeChunks)
+	if len(chunks) == 0 {
+		return "the file is empty", nil
+	}
+
+	instruction := "Summarize this part of a file concisely, keeping names, numbers and structure that matter."
+	if focus != "" {
+		instruction += " Focus on: " + focus
+	}
+	var summaries []string
+	for i, chunk := range chunks {
+		summary, err := agent.auxComplete(ctx, instruction, fmt.Sprintf("%s, part %d of %d:\n\n%s", path, i+1, len(chunks), chunk))
+		if err != nil {
+			return "", fmt.Errorf("summarize part %d: %w", i+1, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	summary := summaries[0]
+	if len(summaries) > 1 {
+		combine := "Combine these summaries of consecutive parts of one file into a single summary."
+		if focus != "" {
+			combine += " Focus on: " + focus
+		}
+		summary, err = agent.auxComplete(ctx, combine, strings.Join(summaries, "\n\n---\n\n"))
+		if err != nil {
+			return "", fmt.Errorf("combine summaries: %w", err)
+		}
+	}
+	if truncated {
+		summary += fmt.Sprintf("\n\n(only the first %d of %d bytes were summarized)", maxSummarizeChunks*summarizeChunkBytes, len(data))
+	}
+	return summary, nil
+}
+
+// auxComplete makes a one-off provider call without tools or history.
+func (agent *Agent) auxComplete(ctx context.Context, instruction, content string) (string, error) {
+	reqBody := ProviderRequest{
+		Stream: false,
+		Messages: []UserMessage{
+			{Role: "system", Content: instruction},
+			{Role: "user", Content: content},
+		},
+		Options: agent.requestOptions(),
+	}
+	resp, err := agent.provider.sendChatRequest(ctx, reqBody)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Message.Content), nil
+}
+
+// splitChunks cuts s into at most max pieces of about size bytes, ending
+// each at the last line break within it when there is one. It reports
+// whether text was left over.
+func splitChunks(s string, size, max int) ([]string, bool) {
+	var chunks []string
+	for len(s) > 0 && len(chunks) < max {
+		if len(s) <= size {
+			chunks = append(chunks, s)
+			s = ""
+			break
+		}
+		cut := strings.LastIndexByte(s[:size], '\n') + 1
+		if cut == 0 {
+			cut = size
+		}
+		chunks = append(chunks, s[:cut])
+		s = s[cut:]
+	}
+	return chunks, len(s) > 0
+}
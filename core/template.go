@@ -0,0 +1,55 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const maxTemplateOutput = 1 << 20 // 1MB
+
+// templateFuncs are the only functions available to templates besides the
+// text/template builtins. They are pure string helpers with no access to
+// the file system, environment or processes.
+var templateFuncs = template.FuncMap{
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trimSpace": strings.TrimSpace,
+	"replace":   strings.ReplaceAll,
+	"join": func(sep string, items []any) string {
+		parts := make([]string, len(items))
+		for i, it := range items {
+			parts[i] = fmt.Sprint(it)
+		}
+		return strings.Join(parts, sep)
+	},
+}
+
+// limitedBuffer fails writes once the output grows past its limit, so a
+// runaway range can't build an enormous result.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("output exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// renderTemplate executes a Go text/template with vars as its data. Missing
+// map keys are an error rather than "<no value>".
+func renderTemplate(text string, vars map[string]any) (string, error) {
+	tmpl, err := template.New("template").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+	out := &limitedBuffer{limit: maxTemplateOutput}
+	if err := tmpl.Execute(out, vars); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+	return out.String(), nil
+}
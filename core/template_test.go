@@ -0,0 +1,57 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		vars map[string]any
+		want string
+	}{
+		{"variables", "Hello {{.name}}, you are {{.age}}", map[string]any{"name": "Ada", "age": float64(36)}, "Hello Ada, you are 36"},
+		{"nested", "{{.user.name}}", map[string]any{"user": map[string]any{"name": "Ada"}}, "Ada"},
+		{"range", "{{range $i, $x := .items}}{{if $i}}, {{end}}{{$x}}{{end}}", map[string]any{"items": []any{"a", "b", "c"}}, "a, b, c"},
+		{"range else", "{{range .items}}{{.}}{{else}}none{{end}}", map[string]any{"items": []any{}}, "none"},
+		{"funcs", `{{upper .s}} {{lower .s}} [{{trimSpace "  x "}}] {{replace .s "b" "B"}} {{join "-" .items}}`, map[string]any{"s": "aBb", "items": []any{"x", float64(1)}}, "ABB abb [x] aBB x-1"},
+		{"no vars", "plain text", nil, "plain text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.text, tt.vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		vars    map[string]any
+		wantErr string
+	}{
+		// missingkey=error: no silent "<no value>"
+		{"missing key", "Hello {{.name}}", map[string]any{}, `map has no entry for key "name"`},
+		{"missing nested key", "{{.user.email}}", map[string]any{"user": map[string]any{"name": "Ada"}}, `map has no entry for key "email"`},
+		{"parse error", "{{.name", nil, "parse template"},
+		{"unknown function", `{{env "HOME"}}`, nil, `function "env" not defined`},
+		{"output limit", `{{range .items}}{{$.big}}{{end}}`, map[string]any{"big": strings.Repeat("x", 1<<10), "items": make([]any, 2<<10)}, "output exceeds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderTemplate(tt.text, tt.vars)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("renderTemplate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package core
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -38,350 +41,1762 @@ type ToolCall struct {
 	} `json:"function"`
 }
 
+// UnmarshalJSON accepts arguments both as a JSON object and, as some models
+// send them, as a string containing a JSON object.
+func (t *ToolCall) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Function struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	t.ID, t.Type, t.Function.Name = raw.ID, raw.Type, raw.Function.Name
+	t.Function.Arguments = nil
+	argData := raw.Function.Arguments
+	if len(argData) > 0 && argData[0] == '"' {
+		var s string
+		if err := json.Unmarshal(argData, &s); err != nil {
+			return err
+		}
+		argData = []byte(s)
+	}
+	if len(argData) == 0 || string(argData) == "null" {
+		return nil
+	}
+	return json.Unmarshal(argData, &t.Function.Arguments)
+}
+
+// DefaultListDepth is how many directory levels list_files descends when
+// the model doesn't pass max_depth.
+var DefaultListDepth = 8
+
+// DefaultShellStrict makes run_shell stop at the first failing command
+// unless the call passes strict=false. Set KUTAGENT_SHELL_STRICT to enable.
+var DefaultShellStrict = os.Getenv("KUTAGENT_SHELL_STRICT") != ""
+
+// AllowedDestructiveTools lists the tools that remove data which may run.
+// Embedders can delete an entry to turn that tool off for every agent.
+var AllowedDestructiveTools = map[string]bool{"delete_file": true}
+
+// destructiveTools are the tools subject to AllowedDestructiveTools.
+var destructiveTools = map[string]bool{"delete_file": true}
+
+// destructiveToolBlocked reports whether name is destructive and not allowed.
+func destructiveToolBlocked(name string) bool {
+	return destructiveTools[name] && !AllowedDestructiveTools[name]
+}
+
+// MutatingTools are the tools that run commands or change files, which
+// Agent.Approve is asked about. Embedders can add their own tools to it.
+var MutatingTools = map[string]bool{
+	"run_shell":         true,
+	"run_shell_to_file": true,
+	"assert_command":    true,
+	"start_process":     true,
+	"send_input":        true,
+	"edit_file":         true,
+	"append_file":       true,
+	"apply_patch":       true,
+	"replace_lines":     true,
+	"apply_changes":     true,
+	"delete_file":       true,
+	"chmod":             true,
+	"git_commit":        true,
+}
+
+const strictShellPrelude = "set -e\n(set -o pipefail) 2>/dev/null && set -o pipefail\n"
+
 type RunTool interface {
 	Run(ctx context.Context) (any, error)
 }
 
+// Run executes the tool call with the tool registered under its name in
+// DefaultRegistry.
 func (t *ToolCall) Run(ctx context.Context) (string, error) {
-	name := t.Function.Name
-	args := t.Function.Arguments
-	fmt.Printf("\u001B[91mTool\u001B[0m:  %s with args %v\n", name, args)
-	switch name {
-	case "time_now":
-		return time.Now().Format(time.RFC3339), nil
-	case "read_file":
-		p, _ := args["path"].(string)
-		if p == "" {
-			return "", fmt.Errorf("missing required argument: path")
-		}
-		// Sanitize and scope to project root
-		root, err := os.Getwd()
-		if err != nil {
-			return "", fmt.Errorf("getwd: %w", err)
-		}
-		clean := filepath.Clean(p)
-		joined := filepath.Join(root, clean)
-		// Ensure the resolved path stays within root
-		rootWithSep := root + string(os.PathSeparator)
-		if !(joined == root || strings.HasPrefix(joined, rootWithSep)) {
-			return "", fmt.Errorf("access outside project root is not allowed")
-		}
-		fi, err := os.Stat(joined)
+	tool, ok := DefaultRegistry.Lookup(t.Function.Name)
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", t.Function.Name)
+	}
+	return tool.Execute(ctx, t.Function.Arguments)
+}
+
+// The built-in tools. builtinTools pairs each with its definition.
+func toolTimeNow(ctx context.Context, args map[string]any) (string, error) {
+	return Now().Format(time.RFC3339), nil
+}
+
+func toolRegexTest(ctx context.Context, args map[string]any) (string, error) {
+	pattern, _ := args["pattern"].(string)
+	if pattern == "" {
+		return "", fmt.Errorf("missing required argument: pattern")
+	}
+	text, _ := args["text"].(string)
+	return regexTest(pattern, text)
+}
+
+func toolCSVQuery(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	where, _ := args["where"].(string)
+	format, _ := args["format"].(string)
+	return csvQuery(joined, stringListArg(args, "select"), where, format)
+}
+
+func toolRandom(ctx context.Context, args map[string]any) (string, error) {
+	return randomValue(args)
+}
+
+func toolToolVersions(ctx context.Context, args map[string]any) (string, error) {
+	return toolVersions(ctx), nil
+}
+
+func toolReadFile(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	// Sanitize and scope to project root
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(joined)
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("path is a directory, not a file")
+	}
+	maxSize := limitsFrom(ctx).FileBytes
+	if fi.Size() > int64(maxSize) {
+		return "", fmt.Errorf("file too large: %d bytes (limit %d)", fi.Size(), maxSize)
+	}
+	encoding, _ := args["encoding"].(string)
+	if encoding != "" && encoding != "text" && encoding != "base64" {
+		return "", fmt.Errorf("encoding must be text or base64")
+	}
+	b, err := os.ReadFile(joined)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	if encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+	if looksBinary(b) {
+		return fmt.Sprintf("file appears to be binary or non-UTF-8; %d bytes (pass encoding: \"base64\" to read it anyway)", len(b)), nil
+	}
+	if numbered, _ := args["with_line_numbers"].(bool); numbered {
+		return numberLines(string(b)), nil
+	}
+	return string(b), nil
+}
+
+func toolSearchFiles(ctx context.Context, args map[string]any) (string, error) {
+	pattern, _ := args["pattern"].(string)
+	if pattern == "" {
+		return "", fmt.Errorf("missing required argument: pattern")
+	}
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(joined); err != nil {
+		return "", fmt.Errorf("stat path: %w", err)
+	}
+	useRegex, _ := args["regex"].(bool)
+	return searchFiles(root, joined, pattern, useRegex)
+}
+
+func toolListFiles(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	rootWithSep := root + string(os.PathSeparator)
+	info, err := os.Stat(joined)
+	if err != nil {
+		return "", fmt.Errorf("stat path: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("path is not a directory")
+	}
+	// Paths are relative to the project root unless asked otherwise
+	absolute, _ := args["absolute"].(bool)
+	maxDepth := intArg(args, "max_depth", DefaultListDepth)
+	omittedDirs := 0
+	// Walk the directory tree and collect files
+	paths := make([]string, 0, 64)
+	const maxEntries = 5000
+	maxOutputBytes := limitsFrom(ctx).ListOutputBytes
+	var totalBytes int
+	err = filepath.WalkDir(joined, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			return "", fmt.Errorf("stat file: %w", err)
-		}
-		if fi.IsDir() {
-			return "", fmt.Errorf("path is a directory, not a file")
-		}
-		const maxSize = 1 << 20 // 1MB
-		if fi.Size() > maxSize {
-			return "", fmt.Errorf("file too large: %d bytes (limit %d)", fi.Size(), maxSize)
+			return err
 		}
-		b, err := os.ReadFile(joined)
-		if err != nil {
-			return "", fmt.Errorf("read file: %w", err)
+		if d.IsDir() {
+			if path != joined && strings.Count(path[len(joined):], string(os.PathSeparator)) >= maxDepth {
+				omittedDirs++
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		return string(b), nil
-	case "list_files":
-		p, _ := args["path"].(string)
-		if p == "" {
-			return "", fmt.Errorf("missing required argument: path")
+		// Ensure still under root (defense in depth)
+		if !withinDir(root, path) {
+			return nil
 		}
-		root, err := os.Getwd()
-		if err != nil {
-			return "", fmt.Errorf("getwd: %w", err)
+		if !absolute {
+			path = strings.TrimPrefix(path, rootWithSep)
 		}
-		clean := filepath.Clean(p)
-		joined := filepath.Join(root, clean)
-		rootWithSep := root + string(os.PathSeparator)
-		if !(joined == root || strings.HasPrefix(joined, rootWithSep)) {
-			return "", fmt.Errorf("access outside project root is not allowed")
+		paths = append(paths, path)
+		if len(paths) >= maxEntries {
+			return filepath.SkipDir
 		}
-		info, err := os.Stat(joined)
-		if err != nil {
-			return "", fmt.Errorf("stat path: %w", err)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("walk dir: %w", err)
+	}
+	// Build output string with size guard
+	var b strings.Builder
+	for i, fp := range paths {
+		if i > 0 {
+			b.WriteString("\n")
+			totalBytes++
 		}
-		if !info.IsDir() {
-			return "", fmt.Errorf("path is not a directory")
+		b.WriteString(fp)
+		totalBytes += len(fp)
+		if totalBytes > maxOutputBytes {
+			b.WriteString(truncationNotice(maxOutputBytes))
+			break
 		}
-		// Walk the directory tree and collect files
-		paths := make([]string, 0, 64)
-		const maxEntries = 5000
-		const maxOutputBytes = 1 << 20 // 1MB
-		var totalBytes int
-		err = filepath.WalkDir(joined, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if d.IsDir() {
-				return nil
-			}
-			// Ensure still under root (defense in depth)
-			if !(path == root || strings.HasPrefix(path, rootWithSep)) {
-				return nil
-			}
-			paths = append(paths, path)
-			if len(paths) >= maxEntries {
-				return filepath.SkipDir
-			}
-			return nil
-		})
-		if err != nil {
-			return "", fmt.Errorf("walk dir: %w", err)
-		}
-		// Build output string with size guard
-		var b strings.Builder
-		for i, fp := range paths {
-			if i > 0 {
-				b.WriteString("\n")
-				totalBytes++
+	}
+	if omittedDirs > 0 {
+		fmt.Fprintf(&b, "\n... %d directories deeper than max_depth %d omitted ...", omittedDirs, maxDepth)
+	}
+	return b.String(), nil
+}
+
+func toolRunShell(ctx context.Context, args map[string]any) (string, error) {
+	cmdStr, _ := args["command"].(string)
+	if cmdStr == "" {
+		return "", fmt.Errorf("missing required argument: command")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	cwd, _ := args["cwd"].(string)
+	dir, err := commandDir(root, cwd)
+	if err != nil {
+		return "", err
+	}
+	env, err := parseEnvArg(args["env"])
+	if err != nil {
+		return "", err
+	}
+	replaceEnv, _ := args["replace_env"].(bool)
+	// parse optional timeout_sec
+	timeoutSec := 30
+	if v, ok := args["timeout_sec"]; ok {
+		switch t := v.(type) {
+		case float64:
+			if t > 0 {
+				timeoutSec = int(t)
 			}
-			b.WriteString(fp)
-			totalBytes += len(fp)
-			if totalBytes > maxOutputBytes {
-				b.WriteString("\n... truncated due to output size limit ...")
-				break
+		case int:
+			if t > 0 {
+				timeoutSec = t
 			}
 		}
-		return b.String(), nil
-	case "run_shell":
-		cmdStr, _ := args["command"].(string)
-		if cmdStr == "" {
-			return "", fmt.Errorf("missing required argument: command")
-		}
-		// parse optional timeout_sec
-		timeoutSec := 30
-		if v, ok := args["timeout_sec"]; ok {
-			switch t := v.(type) {
-			case float64:
-				if t > 0 {
-					timeoutSec = int(t)
-				}
-			case int:
-				if t > 0 {
-					timeoutSec = t
-				}
-			}
+	}
+	// run the command via shell; the tool round's deadline still applies
+	cctx, cancelCmd := context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
+	defer cancelCmd()
+	strict := DefaultShellStrict
+	if v, ok := args["strict"].(bool); ok {
+		strict = v
+	}
+	if strict {
+		// Stop at the first failing command, including inside pipelines
+		// where the shell supports pipefail
+		cmdStr = strictShellPrelude + cmdStr
+	}
+	cmd := exec.CommandContext(cctx, "sh", "-c", cmdStr)
+	cmd.Dir = dir
+	cmd.Env = commandEnv(ctx, env, replaceEnv)
+	// Children of sh may hold the output pipe open after sh is killed;
+	// don't wait on them forever once the context is done.
+	cmd.WaitDelay = 2 * time.Second
+	var outBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &outBuf
+	start := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(start)
+	exitCode := 0
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			exitCode = ee.ExitCode()
+		} else {
+			exitCode = -1
 		}
-		// run the command via shell
-		cctx := ctx
-		var cancelCmd context.CancelFunc
-		if timeoutSec > 0 {
-			cctx, cancelCmd = context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
-			defer cancelCmd()
-		}
-		cmd := exec.CommandContext(cctx, "sh", "-c", cmdStr)
-		var outBuf bytes.Buffer
-		cmd.Stdout = &outBuf
-		cmd.Stderr = &outBuf
-		err := cmd.Run()
-		exitCode := 0
-		if err != nil {
-			var ee *exec.ExitError
-			if errors.As(err, &ee) {
-				exitCode = ee.ExitCode()
-			} else {
-				exitCode = -1
-			}
+	}
+	output := outBuf.String()
+	maxCmdOutput := limitsFrom(ctx).ShellOutputBytes
+	if len(output) > maxCmdOutput {
+		output = output[:maxCmdOutput] + truncationNotice(maxCmdOutput)
+	}
+	// Keep whatever the command printed before it was killed
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		output += fmt.Sprintf("\n... command stopped: the tool round timed out (Agent.ToolTimeout) before its timeout_sec of %d seconds ...", timeoutSec)
+	} else if errors.Is(cctx.Err(), context.DeadlineExceeded) {
+		output += fmt.Sprintf("\n... command timed out after %d seconds ...", timeoutSec)
+	}
+	header := fmt.Sprintf("exit_code=%d", exitCode)
+	if timing, _ := args["timing"].(bool); timing {
+		header += fmt.Sprintf(" duration=%s", elapsed)
+		if ps := cmd.ProcessState; ps != nil {
+			header += fmt.Sprintf(" user_cpu=%s sys_cpu=%s", ps.UserTime(), ps.SystemTime())
 		}
-		output := outBuf.String()
-		const maxCmdOutput = 1 << 20 // 1MB
-		if len(output) > maxCmdOutput {
-			output = output[:maxCmdOutput] + "\n... truncated due to output size limit ..."
-		}
-		return fmt.Sprintf("exit_code=%d\n%s", exitCode, output), nil
-	case "fetch_url":
-		urlStr, _ := args["url"].(string)
-		if urlStr == "" {
-			return "", fmt.Errorf("missing required argument: url")
-		}
-		// validate URL
-		u, err := url.Parse(urlStr)
-		if err != nil || u.Scheme == "" || u.Host == "" {
-			return "", fmt.Errorf("invalid url")
-		}
-		if u.Scheme != "http" && u.Scheme != "https" {
-			return "", fmt.Errorf("unsupported url scheme: %s", u.Scheme)
-		}
-		// parse optional timeout
-		fetchTimeout := 20
-		if v, ok := args["timeout_sec"]; ok {
-			switch t := v.(type) {
-			case float64:
-				if t > 0 {
-					fetchTimeout = int(t)
-				}
-			case int:
-				if t > 0 {
-					fetchTimeout = t
-				}
+	}
+	return fmt.Sprintf("%s\n%s", header, output), nil
+}
+
+func toolAssertCommand(ctx context.Context, args map[string]any) (string, error) {
+	cmdStr, _ := args["command"].(string)
+	if cmdStr == "" {
+		return "", fmt.Errorf("missing required argument: command")
+	}
+	timeoutSec := intArg(args, "timeout_sec", 30)
+	if timeoutSec < 1 {
+		timeoutSec = 30
+	}
+	want := CommandExpectations{
+		ExitCode:    intArg(args, "expect_exit_code", 0),
+		Contains:    stringListArg(args, "expect_contains"),
+		NotContains: stringListArg(args, "expect_not_contains"),
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return assertCommand(ctx, root, cmdStr, time.Duration(timeoutSec)*time.Second, want)
+}
+
+func toolRunShellToFile(ctx context.Context, args map[string]any) (string, error) {
+	cmdStr, _ := args["command"].(string)
+	if cmdStr == "" {
+		return "", fmt.Errorf("missing required argument: command")
+	}
+	outputPath, _ := args["output_path"].(string)
+	if outputPath == "" {
+		return "", fmt.Errorf("missing required argument: output_path")
+	}
+	timeoutSec := intArg(args, "timeout_sec", 30)
+	if timeoutSec < 1 {
+		timeoutSec = 30
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return runShellToFile(ctx, root, cmdStr, outputPath, timeoutSec)
+}
+
+func toolFetchSelector(ctx context.Context, args map[string]any) (string, error) {
+	urlStr, _ := args["url"].(string)
+	if urlStr == "" {
+		return "", fmt.Errorf("missing required argument: url")
+	}
+	sel, _ := args["selector"].(string)
+	if sel == "" {
+		return "", fmt.Errorf("missing required argument: selector")
+	}
+	withAttrs, _ := args["attributes"].(bool)
+	timeoutSec := intArg(args, "timeout_sec", 20)
+	if timeoutSec < 1 {
+		timeoutSec = 20
+	}
+	return fetchSelector(ctx, urlStr, sel, withAttrs, time.Duration(timeoutSec)*time.Second)
+}
+
+func toolProbeURL(ctx context.Context, args map[string]any) (string, error) {
+	urlStr, _ := args["url"].(string)
+	if urlStr == "" {
+		return "", fmt.Errorf("missing required argument: url")
+	}
+	timeoutSec := intArg(args, "timeout_sec", 10)
+	if timeoutSec < 1 {
+		timeoutSec = 10
+	}
+	return probeURL(ctx, publicHTTPClient, urlStr, time.Duration(timeoutSec)*time.Second)
+}
+
+func toolFetchURL(ctx context.Context, args map[string]any) (string, error) {
+	urlStr, _ := args["url"].(string)
+	if urlStr == "" {
+		return "", fmt.Errorf("missing required argument: url")
+	}
+	// validate URL
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+	// parse optional timeout
+	fetchTimeout := 20
+	if v, ok := args["timeout_sec"]; ok {
+		switch t := v.(type) {
+		case float64:
+			if t > 0 {
+				fetchTimeout = int(t)
+			}
+		case int:
+			if t > 0 {
+				fetchTimeout = t
 			}
 		}
-		cctx := ctx
-		var cancel context.CancelFunc
-		if fetchTimeout > 0 {
-			cctx, cancel = context.WithTimeout(ctx, time.Duration(fetchTimeout)*time.Second)
-			defer cancel()
-		}
-		req, err := http.NewRequestWithContext(cctx, http.MethodGet, urlStr, nil)
-		if err != nil {
-			return "", fmt.Errorf("create request: %w", err)
+	}
+	cctx := ctx
+	var cancel context.CancelFunc
+	if fetchTimeout > 0 {
+		cctx, cancel = context.WithTimeout(ctx, time.Duration(fetchTimeout)*time.Second)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(cctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", "KutAgent/1.0 (+https://example.com)")
+	resp, err := toolHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	maxBytes := limitsFrom(ctx).FetchBytes
+	lr := io.LimitReader(resp.Body, int64(maxBytes)+1)
+	data, err := io.ReadAll(lr)
+	if err != nil {
+		return "", fmt.Errorf("read body: %w", err)
+	}
+	truncated := len(data) > maxBytes
+	if truncated {
+		data = data[:maxBytes]
+	}
+	ct := resp.Header.Get("Content-Type")
+	prefix := fmt.Sprintf("status=%d content_type=\"%s\"\n", resp.StatusCode, ct)
+	var body string
+	renderMarkdown := true
+	if v, ok := args["render_markdown"].(bool); ok {
+		renderMarkdown = v
+	}
+	prettyPrintJSON := true
+	if v, ok := args["pretty_json"].(bool); ok {
+		prettyPrintJSON = v
+	}
+	if isHTMLContentType(ct) {
+		body = htmlToText(data)
+	} else if prettyPrintJSON && !truncated && isJSONContentType(ct) {
+		body = prettyJSON(data)
+	} else if renderMarkdown && isMarkdownContent(ct, urlStr) {
+		body = markdownToText(string(data))
+	} else {
+		body = string(data)
+	}
+	if truncated {
+		body += truncationNotice(maxBytes)
+	}
+	debugf("fetched %s (%d bytes, status %d)", urlStr, len(data), resp.StatusCode)
+	return prefix + body, nil
+}
+
+func toolDeleteFile(ctx context.Context, args map[string]any) (string, error) {
+	if destructiveToolBlocked("delete_file") {
+		return "", errToolNotAllowed
+	}
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	recursive, _ := args["recursive"].(bool)
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return deleteWithinRoot(root, path, recursive)
+}
+
+func toolChmod(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	mode, _ := args["mode"].(string)
+	if mode == "" {
+		return "", fmt.Errorf("missing required argument: mode")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return chmodWithinRoot(root, path, mode)
+}
+
+func toolWaitForFile(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	timeoutSec := intArg(args, "timeout_sec", 30)
+	if timeoutSec < 1 || timeoutSec > maxWaitTimeout {
+		return "", fmt.Errorf("timeout_sec must be between 1 and %d", maxWaitTimeout)
+	}
+	stable, _ := args["stable"].(bool)
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return waitForFile(ctx, root, path, time.Duration(timeoutSec)*time.Second, stable)
+}
+
+func toolApplyChanges(ctx context.Context, args map[string]any) (string, error) {
+	changes, err := parseChanges(args)
+	if err != nil {
+		return "", err
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return applyChanges(root, changes)
+}
+
+func toolApplyPatch(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	patch, _ := args["patch"].(string)
+	if patch == "" {
+		return "", fmt.Errorf("missing required argument: patch")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	return applyPatch(joined, patch)
+}
+
+func toolPathNormalize(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	// Unlike the file tools, report an escape instead of failing on it
+	p = filepath.FromSlash(p)
+	if filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(root, p); err == nil {
+			p = rel
 		}
-		req.Header.Set("Accept", "*/*")
-		req.Header.Set("User-Agent", "KutAgent/1.0 (+https://example.com)")
-		client := &http.Client{Timeout: 0}
-		resp, err := client.Do(req)
+	}
+	clean := filepath.Clean(p)
+	joined, err := resolveWithinRoot(root, clean)
+	if errors.Is(err, errOutsideRoot) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Sprintf("path=%s\ninside_root=false", filepath.ToSlash(clean)), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, statErr := os.Stat(joined)
+	return fmt.Sprintf("path=%s\ninside_root=true\nexists=%t", filepath.ToSlash(clean), statErr == nil), nil
+}
+
+func toolReadPDF(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	u, _ := args["url"].(string)
+	var data []byte
+	switch {
+	case p != "" && u != "":
+		return "", fmt.Errorf("pass either path or url, not both")
+	case p != "":
+		root, err := projectRoot(ctx)
 		if err != nil {
-			return "", fmt.Errorf("request failed: %w", err)
+			return "", err
 		}
-		defer resp.Body.Close()
-		const maxBytes = 1 << 20 // 1MB
-		lr := io.LimitReader(resp.Body, maxBytes+1)
-		data, err := io.ReadAll(lr)
+		joined, err := resolveWithinRoot(root, p)
 		if err != nil {
-			return "", fmt.Errorf("read body: %w", err)
+			return "", err
 		}
-		truncated := len(data) > maxBytes
-		if truncated {
-			data = data[:maxBytes]
-		}
-		ct := resp.Header.Get("Content-Type")
-		prefix := fmt.Sprintf("status=%d content_type=\"%s\"\n", resp.StatusCode, ct)
-		var body string
-		if isHTMLContentType(ct) {
-			body = htmlToText(data)
-		} else {
-			body = string(data)
+		if data, err = readPDFFile(joined); err != nil {
+			return "", err
 		}
-		if truncated {
-			body += "\n... truncated due to 32KB limit ..."
+	case u != "":
+		var err error
+		if data, err = fetchPDF(ctx, u); err != nil {
+			return "", err
 		}
-		fmt.Println(body)
-		return prefix + body, nil
 	default:
-		return "", fmt.Errorf("unknown tool: %s", name)
+		return "", fmt.Errorf("missing required argument: path or url")
 	}
+	return pdfToText(data, limitsFrom(ctx).FileBytes)
 }
 
-func getToolsDefinition() []ToolDef {
-	return []ToolDef{
+func toolDirSize(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(joined)
+	if err != nil {
+		return "", fmt.Errorf("stat path: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("path is not a directory")
+	}
+	breakdown, _ := args["breakdown"].(bool)
+	useGitignore := true
+	if v, ok := args["respect_gitignore"].(bool); ok {
+		useGitignore = v
+	}
+	return dirSize(root, joined, breakdown, useGitignore)
+}
+
+func toolRenderTemplate(ctx context.Context, args map[string]any) (string, error) {
+	text, _ := args["template"].(string)
+	if text == "" {
+		return "", fmt.Errorf("missing required argument: template")
+	}
+	vars, _ := args["vars"].(map[string]any)
+	return renderTemplate(text, vars)
+}
+
+func toolTree(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(joined)
+	if err != nil {
+		return "", fmt.Errorf("stat path: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("path is not a directory")
+	}
+	depth := intArg(args, "max_depth", defaultTreeDepth)
+	if depth > maxTreeDepth {
+		depth = maxTreeDepth
+	}
+	return buildTree(root, joined, depth)
+}
+
+func toolEditFile(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	content, ok := args["content"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing required argument: content")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	return writeFile(joined, content, limitsFrom(ctx).FileBytes)
+}
+
+func toolAppendFile(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	content, ok := args["content"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing required argument: content")
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	return appendFile(joined, content, limitsFrom(ctx).FileBytes)
+}
+
+func toolReplaceLines(ctx context.Context, args map[string]any) (string, error) {
+	p, _ := args["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing required argument: path")
+	}
+	start, end := intArg(args, "start_line", 0), intArg(args, "end_line", 0)
+	if start == 0 || end == 0 {
+		return "", fmt.Errorf("missing required arguments: start_line and end_line")
+	}
+	content, _ := args["content"].(string)
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	joined, err := resolveWithinRoot(root, p)
+	if err != nil {
+		return "", err
+	}
+	return replaceLines(joined, start, end, content, limitsFrom(ctx).FileBytes)
+}
+
+func toolGitCommit(ctx context.Context, args map[string]any) (string, error) {
+	message, _ := args["message"].(string)
+	if message == "" {
+		return "", fmt.Errorf("missing required argument: message")
+	}
+	addAll, _ := args["add_all"].(bool)
+	var paths []string
+	if list, ok := args["paths"].([]any); ok {
+		for _, v := range list {
+			if p, ok := v.(string); ok && p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return gitCommit(ctx, root, message, addAll, paths)
+}
+
+func toolCompileCheck(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return compileCheck(ctx, root, path)
+}
+
+func toolGoDoc(ctx context.Context, args map[string]any) (string, error) {
+	pkg, _ := args["package"].(string)
+	if pkg == "" {
+		return "", fmt.Errorf("missing required argument: package")
+	}
+	symbol, _ := args["symbol"].(string)
+	root, err := projectRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	return goDoc(ctx, root, pkg, symbol)
+}
+
+func toolWebSearch(ctx context.Context, args map[string]any) (string, error) {
+	query, _ := args["query"].(string)
+	if query == "" {
+		return "", fmt.Errorf("missing required argument: query")
+	}
+	maxResults := intArg(args, "max_results", 5)
+	if maxResults > 20 {
+		maxResults = 20
+	}
+	backend, err := searchBackendFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	return webSearch(ctx, backend, query, maxResults)
+}
+
+// builtinTools returns the tools that ship with the agent.
+func builtinTools() []builtinTool {
+	tools := []builtinTool{
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "time_now",
+					Description: "Return the current local time in RFC3339 format",
+					Parameters: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolTimeNow,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "tool_versions",
+					Description: "Report the versions of common development tools installed on PATH (go, git, node, python, rustc, docker, ...). Tools that aren't installed are left out",
+					Parameters: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolToolVersions,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "regex_test",
+					Description: "Try a Go regular expression (RE2 syntax) on sample text before using it on files. Returns the compile error, or a JSON list of matches with byte offsets and capture groups (named groups also by name). Input: { pattern: string, text: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"pattern": map[string]any{"type": "string"},
+							"text":    map[string]any{"type": "string"},
+						},
+						"required":             []string{"pattern", "text"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolRegexTest,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "csv_query",
+					Description: "Query a CSV file inside the project root without writing a script. The first row is the header. Optionally filter rows with where (\"column op value\", op one of =, !=, <, <=, >, >=, contains; numbers compare numerically) and keep only the select columns. Returns at most 500 rows as JSON objects, or as CSV with format \"csv\". Input: { path: string, select?: array, where?: string, format?: \"json\" | \"csv\" }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":   map[string]any{"type": "string"},
+							"select": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+							"where":  map[string]any{"type": "string"},
+							"format": map[string]any{"type": "string", "enum": []string{"json", "csv"}},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolCSVQuery,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "random",
+					Description: "Generate a cryptographically secure random value instead of making one up. Modes: int (a whole number from min to max inclusive), uuid (a version 4 UUID), bytes (n random bytes, at most 1024, as hex or base64) and choice (one of options). Input: { mode: \"int\" | \"uuid\" | \"bytes\" | \"choice\", min?: integer, max?: integer, n?: integer, encoding?: \"hex\" | \"base64\", options?: array }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"mode":     map[string]any{"type": "string"},
+							"min":      map[string]any{"type": "integer"},
+							"max":      map[string]any{"type": "integer"},
+							"n":        map[string]any{"type": "integer"},
+							"encoding": map[string]any{"type": "string"},
+							"options":  map[string]any{"type": "array"},
+						},
+						"required":             []string{"mode"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolRandom,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "read_file",
+					Description: "Read a text file from the current project directory and return its contents. Binary and non-UTF-8 files are reported instead of returned unless encoding is base64. With with_line_numbers, each line is prefixed with its 1-based number and a tab, for use with replace_lines; don't copy the numbers into edits. Input: { path: string, encoding?: \"text\" | \"base64\", with_line_numbers?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":              map[string]any{"type": "string"},
+							"encoding":          map[string]any{"type": "string"},
+							"with_line_numbers": map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolReadFile,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "list_files",
+					Description: "List all files under the given directory path recursively, returning paths relative to the project root (absolute paths if absolute is true). Directories deeper than max_depth are not descended into. Input: { path: string, absolute?: boolean, max_depth?: integer }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":      map[string]any{"type": "string"},
+							"absolute":  map[string]any{"type": "boolean"},
+							"max_depth": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolListFiles,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "search_files",
+					Description: "Search the files under a directory (or a single file) inside the project root for a pattern, like grep -rn, to find where something is defined or used without reading every file. Returns matching lines as path:line: text with paths relative to the project root. The pattern is a literal substring unless regex is true (Go RE2 syntax). Binary files, .git and .gitignored paths are skipped; at most 1000 matches are returned. Input: { pattern: string, path: string, regex?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"pattern": map[string]any{"type": "string"},
+							"path":    map[string]any{"type": "string"},
+							"regex":   map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"pattern", "path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolSearchFiles,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "edit_file",
+					Description: "Create or overwrite a text file inside the project root with the provided content. Missing parent directories are created. Input: { path: string, content: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":    map[string]any{"type": "string"},
+							"content": map[string]any{"type": "string"},
+						},
+						"required":             []string{"path", "content"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolEditFile,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "append_file",
+					Description: "Append text to the end of a file inside the project root, creating it if needed, without rewriting what is already there. Returns the new file size. Input: { path: string, content: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":    map[string]any{"type": "string"},
+							"content": map[string]any{"type": "string"},
+						},
+						"required":             []string{"path", "content"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolAppendFile,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "run_shell",
+					Description: "Run an arbitrary shell command and return its output, stderr, and exit code. Set timing to also report wall-clock duration and CPU time. Set strict to stop at the first failing command and report its exit code. Runs in the project root unless cwd names a directory inside it. Variables in env are set on top of the inherited environment, or make up the whole environment with replace_env. Input: { command: string, cwd?: string, env?: object, replace_env?: boolean, timeout_sec?: integer, timing?: boolean, strict?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"command":     map[string]any{"type": "string"},
+							"cwd":         map[string]any{"type": "string"},
+							"env":         map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+							"replace_env": map[string]any{"type": "boolean"},
+							"timeout_sec": map[string]any{"type": "integer"},
+							"timing":      map[string]any{"type": "boolean"},
+							"strict":      map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"command"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolRunShell,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "assert_command",
+					Description: "Run a shell command and check it: the exit code (default 0), strings the output must contain and strings it must not contain. Returns PASS, or FAIL with each mismatch and the end of the output. Use it to verify tests and builds. Input: { command: string, expect_exit_code?: integer, expect_contains?: string[], expect_not_contains?: string[], timeout_sec?: integer (default 30) }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"command":          map[string]any{"type": "string"},
+							"expect_exit_code": map[string]any{"type": "integer"},
+							"expect_contains": map[string]any{
+								"type":  "array",
+								"items": map[string]any{"type": "string"},
+							},
+							"expect_not_contains": map[string]any{
+								"type":  "array",
+								"items": map[string]any{"type": "string"},
+							},
+							"timeout_sec": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"command"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolAssertCommand,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "run_shell_to_file",
+					Description: "Run a shell command and write its combined stdout and stderr to a file under the project root instead of returning it. Returns the exit code and bytes written; read the file afterwards to inspect parts of it. Use it for commands with large output such as builds. Input: { command: string, output_path: string, timeout_sec?: integer (default 30) }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"command":     map[string]any{"type": "string"},
+							"output_path": map[string]any{"type": "string"},
+							"timeout_sec": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"command", "output_path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolRunShellToFile,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "fetch_url",
+					Description: "Fetch the content of a webpage via HTTP GET. Markdown is rendered as plain text unless render_markdown is false; JSON is indented with long arrays shortened unless pretty_json is false. Input: { url: string, timeout_sec?: integer, render_markdown?: boolean, pretty_json?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"url":             map[string]any{"type": "string"},
+							"timeout_sec":     map[string]any{"type": "integer"},
+							"render_markdown": map[string]any{"type": "boolean"},
+							"pretty_json":     map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"url"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolFetchURL,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "delete_file",
+					Description: "Delete a file inside the project root. Directories are only deleted, with everything in them, when recursive is true. Deleting a path that doesn't exist is an error, and the project root itself can't be deleted. Input: { path: string, recursive?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":      map[string]any{"type": "string"},
+							"recursive": map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolDeleteFile,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "chmod",
+					Description: "Set the permissions of a file or directory inside the project root, e.g. to make a script executable. Input: { path: string, mode: string } where mode is octal such as \"755\" or \"0644\"",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path": map[string]any{"type": "string"},
+							"mode": map[string]any{"type": "string"},
+						},
+						"required":             []string{"path", "mode"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolChmod,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "wait_for_file",
+					Description: "Wait until a file inside the project root exists, e.g. a build artifact written by a background process. With stable, also wait until its size stops changing. Returns met=true|false and how long it waited. Input: { path: string, timeout_sec?: integer (default 30), stable?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":        map[string]any{"type": "string"},
+							"timeout_sec": map[string]any{"type": "integer"},
+							"stable":      map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolWaitForFile,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "apply_changes",
+					Description: "Apply several text replacements across files as one transaction: every old text must occur exactly once (changes to the same file apply in order), all are checked before anything is written, and if a write fails the files already written are restored. Returns a summary per change. Input: { changes: [{ path: string, old: string, new: string }] } (at most 50)",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"changes": map[string]any{
+								"type": "array",
+								"items": map[string]any{
+									"type": "object",
+									"properties": map[string]any{
+										"path": map[string]any{"type": "string"},
+										"old":  map[string]any{"type": "string"},
+										"new":  map[string]any{"type": "string"},
+									},
+									"required": []string{"path", "old", "new"},
+								},
+							},
+						},
+						"required":             []string{"changes"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolApplyChanges,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "apply_patch",
+					Description: "Apply a unified diff to one file inside the project root. Every hunk must match the file's current content (context and removed lines), otherwise nothing is written and the first mismatching line is reported; re-read the file and make the patch again. A hunk that moved because lines were added above it is still found. Returns the number of hunks applied. Input: { path: string, patch: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":  map[string]any{"type": "string"},
+							"patch": map[string]any{"type": "string"},
+						},
+						"required":             []string{"path", "patch"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolApplyPatch,
+		},
 		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "time_now",
-				Description: "Return the current local time in RFC3339 format",
-				Parameters: map[string]any{
-					"type":                 "object",
-					"properties":           map[string]any{},
-					"additionalProperties": false,
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "path_normalize",
+					Description: "Clean up a path and return it relative to the project root, whether it stays inside the root and whether it exists. Use it to check a path before writing to it. Input: { path: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path": map[string]any{"type": "string"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolPathNormalize,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "read_pdf",
+					Description: "Extract the text of a PDF from a project file or an http(s) URL, with page boundaries marked. Input: { path?: string, url?: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path": map[string]any{"type": "string"},
+							"url":  map[string]any{"type": "string"},
+						},
+						"additionalProperties": false,
+					},
 				},
 			},
+			run: toolReadPDF,
 		},
 		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "read_file",
-				Description: "Read a text file from the current project directory and return its contents. Input: { path: string }",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"path": map[string]any{"type": "string"},
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "dir_size",
+					Description: "Report the total size and file count of a directory, optionally broken down by its immediate children, largest first. Ignored files are skipped unless respect_gitignore is false. Input: { path: string, breakdown?: boolean, respect_gitignore?: boolean }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":              map[string]any{"type": "string"},
+							"breakdown":         map[string]any{"type": "boolean"},
+							"respect_gitignore": map[string]any{"type": "boolean"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
 					},
-					"required":             []string{"path"},
-					"additionalProperties": false,
 				},
 			},
+			run: toolDirSize,
 		},
 		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "list_files",
-				Description: "List all files under the given directory path recursively, returning full paths. Input: { path: string }",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"path": map[string]any{"type": "string"},
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "render_template",
+					Description: "Render a Go text/template with the given variables and return the output. Besides the builtins, upper, lower, trimSpace, replace and join are available. Referencing a missing variable is an error. Input: { template: string, vars?: object }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"template": map[string]any{"type": "string"},
+							"vars":     map[string]any{"type": "object"},
+						},
+						"required":             []string{"template"},
+						"additionalProperties": false,
 					},
-					"required":             []string{"path"},
-					"additionalProperties": false,
 				},
 			},
+			run: toolRenderTemplate,
 		},
 		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "edit_file",
-				Description: "Create or overwrite a text file at the given path with provided content. Input: { path: string, content: string }",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"path":    map[string]any{"type": "string"},
-						"content": map[string]any{"type": "string"},
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "tree",
+					Description: "Show the directory structure under path as an indented tree, skipping files ignored by .gitignore. Input: { path: string, max_depth?: integer }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":      map[string]any{"type": "string"},
+							"max_depth": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"path"},
+						"additionalProperties": false,
 					},
-					"required":             []string{"path", "content"},
-					"additionalProperties": false,
 				},
 			},
+			run: toolTree,
 		},
 		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "run_shell",
-				Description: "Run an arbitrary shell command and return its output, stderr, and exit code. Input: { command: string, timeout_sec?: integer }",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"command":     map[string]any{"type": "string"},
-						"timeout_sec": map[string]any{"type": "integer"},
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "replace_lines",
+					Description: "Replace lines start_line through end_line (1-based, inclusive) of a file with content. Empty content deletes the lines. Input: { path: string, start_line: integer, end_line: integer, content: string }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path":       map[string]any{"type": "string"},
+							"start_line": map[string]any{"type": "integer"},
+							"end_line":   map[string]any{"type": "integer"},
+							"content":    map[string]any{"type": "string"},
+						},
+						"required":             []string{"path", "start_line", "end_line", "content"},
+						"additionalProperties": false,
 					},
-					"required":             []string{"command"},
-					"additionalProperties": false,
 				},
 			},
+			run: toolReplaceLines,
 		},
 		{
-			Type: "function",
-			Function: FunctionDef{
-				Name:        "fetch_url",
-				Description: "Fetch the content of a webpage via HTTP GET. Input: { url: string, timeout_sec?: integer }",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"url":         map[string]any{"type": "string"},
-						"timeout_sec": map[string]any{"type": "integer"},
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "compile_check",
+					Description: "Check that Go code builds by running go build in the project root. Returns \"ok\" or a JSON array of {file, line, column, message} compiler errors. Input: { path?: string } (a package directory, with a trailing /... to include subpackages; defaults to every package)",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"path": map[string]any{"type": "string"},
+						},
+						"additionalProperties": false,
 					},
-					"required":             []string{"url"},
-					"additionalProperties": false,
 				},
 			},
+			run: toolCompileCheck,
 		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "go_doc",
+					Description: "Show the documentation of a Go package or one of its symbols via go doc, resolved within the project's module (standard library and dependencies included). Input: { package: string, symbol?: string } e.g. { package: \"net/http\", symbol: \"Client.Do\" }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"package": map[string]any{"type": "string"},
+							"symbol":  map[string]any{"type": "string"},
+						},
+						"required":             []string{"package"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolGoDoc,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "git_commit",
+					Description: "Commit changes in the project's git repository and return the new commit hash. Stages all changes if add_all is true, otherwise the given paths; fails if nothing is staged. Input: { message: string, add_all?: boolean, paths?: string[] }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"message": map[string]any{"type": "string"},
+							"add_all": map[string]any{"type": "boolean"},
+							"paths": map[string]any{
+								"type":  "array",
+								"items": map[string]any{"type": "string"},
+							},
+						},
+						"required":             []string{"message"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolGitCommit,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "fetch_selector",
+					Description: "Fetch a web page and return only the elements matching a CSS selector, as a JSON array of {tag, text, attrs?}. Supports tag, #id, .class and [attr] / [attr=value] selectors with descendant and > combinators, and comma-separated groups. At most 50 matches are returned. Input: { url: string, selector: string, attributes?: boolean, timeout_sec?: integer }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"url":         map[string]any{"type": "string"},
+							"selector":    map[string]any{"type": "string"},
+							"attributes":  map[string]any{"type": "boolean"},
+							"timeout_sec": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"url", "selector"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolFetchSelector,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "probe_url",
+					Description: "Check whether a URL is up and how fast it answers, without downloading the content. Returns the status code, response time, final URL after redirects and Server header. Input: { url: string, timeout_sec?: integer (default 10) }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"url":         map[string]any{"type": "string"},
+							"timeout_sec": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"url"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolProbeURL,
+		},
+		{
+			def: ToolDef{
+				Type: "function",
+				Function: FunctionDef{
+					Name:        "web_search",
+					Description: "Search the web and return a JSON list of results with title, url and snippet. Use fetch_url to read a result. Input: { query: string, max_results?: integer }",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"query":       map[string]any{"type": "string"},
+							"max_results": map[string]any{"type": "integer"},
+						},
+						"required":             []string{"query"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			run: toolWebSearch,
+		},
+	}
+	return append(tools, processTools()...)
+}
+
+// tools returns the registry the agent dispatches through: the tools in
+// DefaultRegistry, then the agent-level tools that need the agent itself,
+// which take precedence over a registered tool of the same name.
+func (agent *Agent) tools() *Registry {
+	r := NewRegistry()
+	for _, t := range DefaultRegistry.Tools() {
+		r.Register(t)
+	}
+	var own []builtinTool
+	if agent.ConfigTools {
+		own = append(own, agent.configTools()...)
+	}
+	own = append(own, agent.planTools()...)
+	own = append(own, agent.bookmarkTools()...)
+	if agent.snapshot != nil {
+		own = append(own, builtinTool{def: sessionDiffDef(), run: agent.sessionDiffTool})
+	}
+	if agent.ToolResultLimit > 0 {
+		own = append(own, builtinTool{def: readToolResultDef(), run: agent.readToolResult})
+	}
+	own = append(own,
+		builtinTool{def: budgetReportDef(), run: agent.budgetReportTool},
+		builtinTool{def: summarizeFileDef(), run: agent.summarizeFile},
+	)
+	for _, t := range own {
+		r.Register(agentTool{t})
+	}
+	return r
+}
+
+// toolsDefinition returns the tools offered to the model, leaving out any
+// that are disabled.
+func (agent *Agent) toolsDefinition() []ToolDef {
+	all := agent.tools().Definitions()
+	tools := make([]ToolDef, 0, len(all))
+	for _, t := range all {
+		if !agent.toolDisabled(t.Function.Name) {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// disabledToolsFromEnv reads the tools disabled for this deployment:
+// KUTAGENT_DISABLED_TOOLS is a comma-separated list, and KUTAGENT_TOOL_<NAME>
+// set to false or true disables or enables a single tool, overriding the list.
+func disabledToolsFromEnv() map[string]bool {
+	disabled := toolListFromEnv("KUTAGENT_DISABLED_TOOLS")
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(key, "KUTAGENT_TOOL_")
+		if !ok {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			continue
+		}
+		name = strings.ToLower(name)
+		if enabled {
+			delete(disabled, name)
+		} else {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// toolListFromEnv reads a comma-separated list of tool names from key.
+func toolListFromEnv(key string) map[string]bool {
+	tools := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv(key), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tools[name] = true
+		}
+	}
+	return tools
+}
+
+func (agent *Agent) toolDisabled(name string) bool {
+	if len(agent.EnabledTools) > 0 && !agent.EnabledTools[name] {
+		return true
+	}
+	return agent.DisabledTools[name] || agent.selfDisabled[name] || destructiveToolBlocked(name)
+}
+
+// runTool executes a single tool call, including the agent-level tools that
+// need access to the agent itself. approved skips Approve for a call that
+// was already approved as part of a batch.
+func (agent *Agent) runTool(ctx context.Context, tc *ToolCall, approved bool) (string, error) {
+	name := tc.Function.Name
+	if agent.toolDisabled(name) {
+		return "", agent.denyError(DenyDisabled, name, nil)
+	}
+	if !approved && agent.Approve != nil && MutatingTools[name] && !agent.Approve(name, tc.Function.Arguments) {
+		return "", agent.denyError(DenyUser, name, nil)
+	}
+	result, err := agent.dispatchTool(agent.toolContext(ctx), tc)
+	if reason, ok := denyReasonOf(err); ok {
+		return result, agent.denyError(reason, name, err)
 	}
+	return result, err
 }
 
-func runTools(ctx context.Context, chatResp ProviderResponse, messages []UserMessage) []UserMessage {
+// toolContext scopes ctx to the agent's project root once it is resolved.
+func (agent *Agent) toolContext(ctx context.Context) context.Context {
+	ctx = withLimits(ctx, agent.Limits)
+	if agent.CleanShellEnv {
+		ctx = withCleanShellEnv(ctx)
+	}
+	if agent.SearchBackend != nil {
+		ctx = withSearchBackend(ctx, agent.SearchBackend)
+	}
+	if agent.processes == nil {
+		agent.processes = newProcessTable()
+	}
+	ctx = withProcessTable(ctx, agent.processes)
+	if agent.root == "" {
+		return ctx
+	}
+	return withProjectRoot(ctx, agent.root)
+}
+
+// dispatchTool runs tc with the tool registered under its name. Results
+// of all but the agent-level tools are shortened to ToolResultLimit.
+func (agent *Agent) dispatchTool(ctx context.Context, tc *ToolCall) (string, error) {
+	tool, ok := agent.tools().Lookup(tc.Function.Name)
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", tc.Function.Name)
+	}
+	result, err := tool.Execute(ctx, tc.Function.Arguments)
+	if _, own := tool.(agentTool); own || err != nil {
+		return result, err
+	}
+	return agent.compactResult(result), nil
+}
+
+// traceTool prints the tool about to run, prefixed with the current step
+// out of MaxSteps when StepCounter is on.
+func (agent *Agent) traceTool(step int, tc ToolCall) {
+	prefix := ""
+	if agent.StepCounter {
+		prefix = fmt.Sprintf("[%d/%d] ", step, agent.maxSteps())
+	}
+	fmt.Fprintf(agent.out(), "%s\u001B[91mTool\u001B[0m:  %s with args %v\n", prefix, tc.Function.Name, tc.Function.Arguments)
+}
+
+// toolErrorPrefix starts the result of a failed tool call, so the model can
+// tell a failure from output that merely mentions an error.
+const toolErrorPrefix = "ERROR: "
+
+// traceToolError shows the user which call failed and why, without waiting
+// for the model to mention it.
+func (agent *Agent) traceToolError(step int, tc ToolCall, err error) {
+	prefix := ""
+	if agent.StepCounter {
+		prefix = fmt.Sprintf("[%d/%d] ", step, agent.maxSteps())
+	}
+	fmt.Fprintf(agent.out(), "%s\u001B[91mTool error\u001B[0m: %s: %v\n", prefix, tc.Function.Name, err)
+}
+
+// approveBatch asks ApproveBatch once about the calls to MutatingTools
+// among calls, when there are several, and returns the decision by call
+// index. Calls without a decision are left to Approve.
+func (agent *Agent) approveBatch(calls []ToolCall) map[int]bool {
+	if agent.ApproveBatch == nil {
+		return nil
+	}
+	var batch []ToolCall
+	var indexes []int
+	for i, tc := range calls {
+		if MutatingTools[tc.Function.Name] && !agent.toolDisabled(tc.Function.Name) {
+			batch = append(batch, tc)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(batch) < 2 {
+		return nil
+	}
+	ok := agent.ApproveBatch(batch)
+	decisions := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		decisions[i] = ok
+	}
+	return decisions
+}
+
+// runTools executes the tool calls of chatResp, appending their results to
+// messages. It also returns how many of the calls failed. step is the
+// 1-based tool round, used for tracing.
+func (agent *Agent) runTools(ctx context.Context, step int, chatResp ProviderResponse, messages []UserMessage) ([]UserMessage, int) {
+	failed := 0
 	// If assistant returned tool calls, execute them and continue the loop
 	if len(chatResp.Message.ToolCalls) > 0 {
 		// Append assistant tool-calling message to history
 		messages = append(messages, UserMessage{Role: chatResp.Message.Role, Content: chatResp.Message.Content})
-		for _, tc := range chatResp.Message.ToolCalls {
+		seen := map[string]bool{}
+		results := make([]UserMessage, 0, len(chatResp.Message.ToolCalls))
+		calls := chatResp.Message.ToolCalls
+		runnable := len(calls)
+		if !agent.caps.SupportsParallelTools {
+			runnable = 1
+		}
+		decisions := agent.approveBatch(calls[:runnable])
+		for i, tc := range calls {
+			// Tool results are paired with their call by ID, so every call needs
+			// a unique one. Ollama often omits IDs; some models repeat them.
+			if tc.ID == "" || seen[tc.ID] {
+				if tc.ID != "" {
+					fmt.Fprintf(agent.ErrOut, "warning: duplicate tool call id %q, assigning a new one\n", tc.ID)
+				}
+				tc.ID = uniqueToolCallID(i, seen)
+			}
+			seen[tc.ID] = true
 			// Use arguments provided by the model (may be nil)
 			args := tc.Function.Arguments
 			if args == nil {
 				args = map[string]any{}
 			}
-			result, err := tc.Run(ctx)
+			if i >= runnable {
+				results = append(results, UserMessage{
+					Role:       "tool",
+					Content:    notRunParallel,
+					ToolCallID: tc.ID,
+					Name:       tc.Function.Name,
+				})
+				continue
+			}
+			agent.traceTool(step, tc)
+			var result string
+			var err error
+			if approved, decided := decisions[i]; decided && !approved {
+				err = agent.denyError(DenyUser, tc.Function.Name, nil)
+			} else {
+				result, err = agent.runTool(ctx, &tc, decided)
+			}
 			if err != nil {
-				result = fmt.Sprintf("tool error: %v", err)
+				result = toolErrorPrefix + err.Error()
+				agent.traceToolError(step, tc, err)
+				failed++
 			}
-			messages = append(messages, UserMessage{
+			results = append(results, UserMessage{
 				Role:       "tool",
 				Content:    result,
 				ToolCallID: tc.ID,
 				Name:       tc.Function.Name,
 			})
 		}
+		if agent.caps.FoldToolResults && len(results) > 1 {
+			results = []UserMessage{foldToolResults(results)}
+		}
+		newest := len(messages)
+		messages = append(messages, results...)
+		if agent.CompactDuplicates {
+			messages = compactDuplicates(messages, newest)
+		}
+	}
+	return messages, failed
+}
+
+// foldToolResults merges the results of one round into a single tool
+// message, labelling each part with the id and name of its call.
+func foldToolResults(results []UserMessage) UserMessage {
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "[tool_call_id=%s name=%s]\n%s", r.ToolCallID, r.Name, r.Content)
+	}
+	return UserMessage{Role: "tool", Content: b.String()}
+}
+
+func uniqueToolCallID(index int, seen map[string]bool) string {
+	id := fmt.Sprintf("call_%d", index)
+	for n := 1; seen[id]; n++ {
+		id = fmt.Sprintf("call_%d_%d", index, n)
 	}
-	return messages
+	return id
+}
+
+// intArg reads an optional positive integer argument, falling back to def.
+// JSON numbers arrive as float64, so both float64 and int are accepted.
+func intArg(args map[string]any, key string, def int) int {
+	switch t := args[key].(type) {
+	case float64:
+		if t > 0 {
+			return int(t)
+		}
+	case int:
+		if t > 0 {
+			return t
+		}
+	}
+	return def
 }
 
 // Helper functions for HTML content handling
@@ -461,3 +1876,21 @@ func htmlToText(data []byte) string {
 	walk(n)
 	return normalizeWS(html.UnescapeString(sb.String()))
 }
+
+// numberLines prefixes each line of s with its 1-based number and a tab. A
+// final line without a trailing newline is numbered too.
+func numberLines(s string) string {
+	if s == "" {
+		return ""
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	width := len(strconv.Itoa(len(lines)))
+	var b strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&b, "%*d\t%s", width, i+1, line)
+	}
+	return b.String()
+}
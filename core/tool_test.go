@@ -0,0 +1,369 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// toolRound is a model reply calling each of calls in turn.
+func toolRound(calls ...*ToolCall) ProviderResponse {
+	resp := ProviderResponse{}
+	resp.Message.Role = "assistant"
+	for _, c := range calls {
+		resp.Message.ToolCalls = append(resp.Message.ToolCalls, *c)
+	}
+	return resp
+}
+
+func withID(tc *ToolCall, id string) *ToolCall {
+	tc.ID = id
+	return tc
+}
+
+func TestRunToolsFolding(t *testing.T) {
+	withRegistry(t, echoTool{"echo"})
+	round := toolRound(
+		withID(call("echo", map[string]any{"text": "one"}), "a"),
+		withID(call("missing", nil), "b"),
+		withID(call("echo", map[string]any{"text": "two"}), "c"),
+	)
+
+	t.Run("unfolded", func(t *testing.T) {
+		agent := &Agent{session: &Session{}, caps: defaultCapabilities}
+		messages, failed := agent.runTools(context.Background(), 1, round, nil)
+		if failed != 1 {
+			t.Errorf("failed = %d, want 1", failed)
+		}
+		want := []UserMessage{
+			{Role: "assistant"},
+			{Role: "tool", Content: "echo: one", ToolCallID: "a", Name: "echo"},
+			{Role: "tool", Content: "ERROR: unknown tool: missing", ToolCallID: "b", Name: "missing"},
+			{Role: "tool", Content: "echo: two", ToolCallID: "c", Name: "echo"},
+		}
+		if len(messages) != len(want) {
+			t.Fatalf("messages = %+v, want %+v", messages, want)
+		}
+		for i := range want {
+			if messages[i] != want[i] {
+				t.Errorf("message %d = %+v, want %+v", i, messages[i], want[i])
+			}
+		}
+	})
+
+	t.Run("folded", func(t *testing.T) {
+		agent := &Agent{session: &Session{}, caps: ModelCapabilities{SupportsParallelTools: true, FoldToolResults: true}}
+		messages, failed := agent.runTools(context.Background(), 1, round, nil)
+		if failed != 1 {
+			t.Errorf("failed = %d, want 1", failed)
+		}
+		if len(messages) != 2 || messages[1].Role != "tool" {
+			t.Fatalf("messages = %+v, want the assistant turn and one tool message", messages)
+		}
+		want := "[tool_call_id=a name=echo]\necho: one\n\n" +
+			"[tool_call_id=b name=missing]\nERROR: unknown tool: missing\n\n" +
+			"[tool_call_id=c name=echo]\necho: two"
+		if messages[1].Content != want {
+			t.Errorf("folded content = %q, want %q", messages[1].Content, want)
+		}
+	})
+
+	t.Run("folded single result", func(t *testing.T) {
+		agent := &Agent{session: &Session{}, caps: ModelCapabilities{FoldToolResults: true}}
+		messages, _ := agent.runTools(context.Background(), 1, toolRound(withID(call("echo", map[string]any{"text": "x"}), "only")), nil)
+		if len(messages) != 2 || messages[1].ToolCallID != "only" || strings.Contains(messages[1].Content, "tool_call_id=") {
+			t.Errorf("a single result should be left as is, got %+v", messages)
+		}
+	})
+}
+
+func TestNumberLines(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", ""},
+		{"final newline", "a\nb\n", "1\ta\n2\tb\n"},
+		{"no final newline", "a\nb", "1\ta\n2\tb"},
+		{"single line", "only", "1\tonly"},
+		{"blank final line", "a\n\n", "1\ta\n2\t\n"},
+		{"blank lines", "\n\nc", "1\t\n2\t\n3\tc"},
+		{"crlf", "a\r\nb\r\n", "1\ta\r\n2\tb\r\n"},
+		{"width", strings.Repeat("x\n", 9) + "last", " 1\tx\n 2\tx\n 3\tx\n 4\tx\n 5\tx\n 6\tx\n 7\tx\n 8\tx\n 9\tx\n10\tlast"},
+	}
+	for _, tt := range tests {
+		if got := numberLines(tt.in); got != tt.want {
+			t.Errorf("%s: numberLines(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadFileWithLineNumbers(t *testing.T) {
+	root, _ := newRoot(t)
+	if err := os.WriteFile(filepath.Join(root, "f.txt"), []byte("one\ntwo\nthree"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := withProjectRoot(context.Background(), root)
+	tool, _ := DefaultRegistry.Lookup("read_file")
+	tests := []struct {
+		args map[string]any
+		want string
+	}{
+		{map[string]any{"path": "f.txt"}, "one\ntwo\nthree"},
+		{map[string]any{"path": "f.txt", "with_line_numbers": true}, "1\tone\n2\ttwo\n3\tthree"},
+	}
+	for _, tt := range tests {
+		got, err := tool.Execute(ctx, tt.args)
+		if err != nil || got != tt.want {
+			t.Errorf("read_file %v = %q, %v; want %q", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestRunToolsOneCallPerTurn(t *testing.T) {
+	withRegistry(t, echoTool{"echo"}, echoTool{"edit_file"})
+	var batched bool
+	var asked []string
+	agent := &Agent{
+		session:      &Session{},
+		caps:         ModelCapabilities{SupportsParallelTools: false},
+		Approve:      func(name string, _ map[string]any) bool { asked = append(asked, name); return true },
+		ApproveBatch: func([]ToolCall) bool { batched = true; return true },
+	}
+	messages, failed := agent.runTools(context.Background(), 1, toolRound(
+		withID(call("edit_file", map[string]any{"text": "one"}), "a"),
+		withID(call("edit_file", map[string]any{"text": "two"}), "b"),
+		withID(call("echo", map[string]any{"text": "three"}), "c"),
+	), nil)
+	want := []UserMessage{
+		{Role: "assistant"},
+		{Role: "tool", Content: "edit_file: one", ToolCallID: "a", Name: "edit_file"},
+		{Role: "tool", Content: notRunParallel, ToolCallID: "b", Name: "edit_file"},
+		{Role: "tool", Content: notRunParallel, ToolCallID: "c", Name: "echo"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("messages = %+v, want %+v", messages, want)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, messages[i], want[i])
+		}
+	}
+	// Calls that are not run are neither failures nor asked about
+	if failed != 0 || batched || strings.Join(asked, ",") != "edit_file" {
+		t.Errorf("failed = %d, batched = %t, asked = %v; want only the first call asked about", failed, batched, asked)
+	}
+}
+
+func TestApproveBatch(t *testing.T) {
+	withRegistry(t, echoTool{"edit_file"}, echoTool{"delete_file"}, echoTool{"read_file"})
+	round := toolRound(
+		call("edit_file", map[string]any{"text": "a"}),
+		call("read_file", map[string]any{"text": "b"}),
+		call("delete_file", map[string]any{"text": "c"}),
+	)
+	for _, ok := range []bool{true, false} {
+		var batches [][]string
+		var asked []string
+		agent := &Agent{
+			ErrOut:  &strings.Builder{},
+			session: &Session{},
+			caps:    defaultCapabilities,
+			Approve: func(name string, _ map[string]any) bool {
+				asked = append(asked, name)
+				return true
+			},
+			ApproveBatch: func(calls []ToolCall) bool {
+				var names []string
+				for _, tc := range calls {
+					names = append(names, tc.Function.Name)
+				}
+				batches = append(batches, names)
+				return ok
+			},
+		}
+		messages, failed := agent.runTools(context.Background(), 1, round, nil)
+		if len(batches) != 1 || strings.Join(batches[0], ",") != "edit_file,delete_file" || len(asked) != 0 {
+			t.Fatalf("batches = %v, asked one by one = %v; want one batch of the two changes", batches, asked)
+		}
+		want := []string{"edit_file: a", "read_file: b", "delete_file: c"}
+		if !ok {
+			denied := toolErrorPrefix + agent.denyError(DenyUser, "edit_file", nil).Error()
+			want[0] = denied
+			want[2] = strings.Replace(denied, "edit_file", "delete_file", 1)
+		}
+		for i, w := range want {
+			if messages[i+1].Content != w {
+				t.Errorf("approved %t: result %d = %q, want %q", ok, i, messages[i+1].Content, w)
+			}
+		}
+		if wantFailed := map[bool]int{true: 0, false: 2}[ok]; failed != wantFailed {
+			t.Errorf("approved %t: failed = %d, want %d", ok, failed, wantFailed)
+		}
+	}
+
+	// A single change is asked about on its own
+	var batched bool
+	var asked []string
+	agent := &Agent{
+		ErrOut:       &strings.Builder{},
+		session:      &Session{},
+		caps:         defaultCapabilities,
+		Approve:      func(name string, _ map[string]any) bool { asked = append(asked, name); return false },
+		ApproveBatch: func([]ToolCall) bool { batched = true; return true },
+	}
+	_, failed := agent.runTools(context.Background(), 1, toolRound(call("edit_file", nil), call("read_file", nil)), nil)
+	if batched || strings.Join(asked, ",") != "edit_file" || failed != 1 {
+		t.Errorf("batched = %t, asked = %v, failed = %d; want edit_file asked on its own and denied", batched, asked, failed)
+	}
+}
+
+func TestRunShellTimeoutKeepsOutput(t *testing.T) {
+	root, _ := newRoot(t)
+	tool, _ := DefaultRegistry.Lookup("run_shell")
+	start := time.Now()
+	got, err := tool.Execute(withProjectRoot(context.Background(), root), map[string]any{
+		"command":     "echo start; exec sleep 5",
+		"timeout_sec": float64(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("run_shell took %s despite timeout_sec 1", elapsed)
+	}
+	if !strings.Contains(got, "\nstart\n") || !strings.Contains(got, "command timed out after 1 seconds") {
+		t.Errorf("run_shell = %q, want the output printed before the timeout and a timeout note", got)
+	}
+}
+
+func TestRunShellTiming(t *testing.T) {
+	root, _ := newRoot(t)
+	tool, _ := DefaultRegistry.Lookup("run_shell")
+	ctx := withProjectRoot(context.Background(), root)
+	got, err := tool.Execute(ctx, map[string]any{"command": "sleep 0.2", "timing": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, _ := strings.Cut(got, "\n")
+	fields := strings.Fields(header)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "duration=") {
+		t.Fatalf("header = %q, want exit_code then duration", header)
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(fields[1], "duration="))
+	if err != nil || d < 200*time.Millisecond {
+		t.Errorf("duration = %s, %v; want at least the 200ms slept", d, err)
+	}
+
+	got, err = tool.Execute(ctx, map[string]any{"command": "true"})
+	if err != nil || strings.Contains(got, "duration=") {
+		t.Errorf("without timing: %q, %v; want no duration", got, err)
+	}
+}
+
+func TestFetchDoesNotPrintBody(t *testing.T) {
+	const secret = "page-body-marker"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><p>" + secret + "</p></body></html>"))
+	}))
+	defer srv.Close()
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+	printed := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		printed <- string(b)
+	}()
+
+	var out strings.Builder
+	agent := &Agent{Out: &out, ErrOut: &out, session: &Session{}, caps: defaultCapabilities}
+	messages, failed := agent.runTools(context.Background(), 1, toolRound(
+		call("fetch_url", map[string]any{"url": srv.URL}),
+		call("fetch_selector", map[string]any{"url": srv.URL, "selector": "p"}),
+	), nil)
+	w.Close()
+	os.Stdout = stdout
+
+	if failed != 0 {
+		t.Fatalf("tool results: %+v", messages)
+	}
+	for _, m := range messages[1:] {
+		if !strings.Contains(m.Content, secret) {
+			t.Errorf("%s result = %q, want the body", m.Name, m.Content)
+		}
+	}
+	if got := <-printed; strings.Contains(got, secret) {
+		t.Errorf("stdout = %q, want the body left out", got)
+	}
+	if strings.Contains(out.String(), secret) {
+		t.Errorf("agent output = %q, want the body left out", out.String())
+	}
+}
+
+func TestListFilesMaxDepth(t *testing.T) {
+	root, _ := newRoot(t)
+	// l<n>.txt is n levels below the root
+	files := []string{"l1.txt", "a/l2.txt", "a/b/l3.txt", "a/b/c/l4.txt", "a/b/c/d/l5.txt", "a/b/c/d/e/l6.txt"}
+	for _, f := range files {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tool, _ := DefaultRegistry.Lookup("list_files")
+	ctx := withProjectRoot(context.Background(), root)
+	tests := []struct {
+		path     string
+		maxDepth int
+		kept     []string
+		omitted  []string
+	}{
+		{".", 0, files, nil},
+		{".", 4, files[:4], files[4:]},
+		{".", 1, files[:1], files[1:]},
+		// Depth counts from the listed directory
+		{"a/b", 2, files[2:4], files[4:]},
+	}
+	for _, tt := range tests {
+		args := map[string]any{"path": tt.path}
+		if tt.maxDepth > 0 {
+			args["max_depth"] = float64(tt.maxDepth)
+		}
+		got, err := tool.Execute(ctx, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed := map[string]bool{}
+		for _, line := range strings.Split(got, "\n") {
+			listed[filepath.ToSlash(line)] = true
+		}
+		for _, f := range tt.kept {
+			if !listed[f] {
+				t.Errorf("list_files %v left out %s:\n%s", args, f, got)
+			}
+		}
+		for _, f := range tt.omitted {
+			if listed[f] {
+				t.Errorf("list_files %v listed %s below max_depth:\n%s", args, f, got)
+			}
+		}
+		if note := strings.Contains(got, "deeper than max_depth"); note != (len(tt.omitted) > 0) {
+			t.Errorf("list_files %v: omission note %t, want %t\n%s", args, note, len(tt.omitted) > 0, got)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultTreeDepth = 3
+	maxTreeDepth     = 10
+	maxTreeNodes     = 1000
+)
+
+// buildTree renders dir like the Unix tree command. Entries matched by the
+// project's .gitignore and the .git directory are skipped.
+func buildTree(root, dir string, maxDepth int) (string, error) {
+	gi := loadGitignore(root)
+	var b strings.Builder
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(filepath.ToSlash(rel) + "/\n")
+
+	nodes, truncated := 0, false
+	var walk func(dir, prefix string, depth int) error
+	walk = func(dir, prefix string, depth int) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		visible := entries[:0]
+		for _, e := range entries {
+			if e.Name() == ".git" {
+				continue
+			}
+			rel, err := filepath.Rel(root, filepath.Join(dir, e.Name()))
+			if err != nil {
+				return err
+			}
+			if gi.Match(filepath.ToSlash(rel), e.IsDir()) {
+				continue
+			}
+			visible = append(visible, e)
+		}
+		sort.Slice(visible, func(i, j int) bool { return visible[i].Name() < visible[j].Name() })
+		for i, e := range visible {
+			if nodes >= maxTreeNodes {
+				truncated = true
+				return nil
+			}
+			nodes++
+			branch, indent := "├── ", "│   "
+			if i == len(visible)-1 {
+				branch, indent = "└── ", "    "
+			}
+			name := e.Name()
+			if e.IsDir() {
+				name += "/"
+			}
+			b.WriteString(prefix + branch + name + "\n")
+			if e.IsDir() && depth < maxDepth {
+				if err := walk(filepath.Join(dir, e.Name()), prefix+indent, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(dir, "", 1); err != nil {
+		return "", fmt.Errorf("read dir: %w", err)
+	}
+	if truncated {
+		b.WriteString(fmt.Sprintf("... truncated after %d entries ...\n", maxTreeNodes))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// Usage is what a request cost as reported by Ollama in its final response:
+// tokens read from the prompt, tokens generated and the time it took.
+type Usage struct {
+	PromptTokens     int           `json:"prompt_eval_count,omitempty"`
+	CompletionTokens int           `json:"eval_count,omitempty"`
+	TotalDuration    time.Duration `json:"total_duration,omitempty"`
+}
+
+// Add adds other to u.
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalDuration += other.TotalDuration
+}
+
+// Sub returns u minus other, e.g. one turn's share of a running total.
+func (u Usage) Sub(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens - other.PromptTokens,
+		CompletionTokens: u.CompletionTokens - other.CompletionTokens,
+		TotalDuration:    u.TotalDuration - other.TotalDuration,
+	}
+}
+
+// formatUsage is not Usage.String, since ProviderResponse embeds Usage and
+// would then print as just its usage.
+func formatUsage(u Usage) string {
+	return fmt.Sprintf("%d prompt + %d completion tokens, %s", u.PromptTokens, u.CompletionTokens, u.TotalDuration.Round(10*time.Millisecond))
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionProbes lists the tools reported by tool_versions and how to ask
+// each for its version.
+var versionProbes = []struct {
+	name string
+	args []string
+}{
+	{"go", []string{"version"}},
+	{"git", []string{"--version"}},
+	{"node", []string{"--version"}},
+	{"npm", []string{"--version"}},
+	{"python3", []string{"--version"}},
+	{"python", []string{"--version"}},
+	{"pip3", []string{"--version"}},
+	{"rustc", []string{"--version"}},
+	{"cargo", []string{"--version"}},
+	{"java", []string{"-version"}},
+	{"gcc", []string{"--version"}},
+	{"make", []string{"--version"}},
+	{"docker", []string{"--version"}},
+}
+
+// toolVersions probes every tool in versionProbes concurrently and returns
+// the first line of each version output. Tools not on PATH are skipped.
+func toolVersions(ctx context.Context) string {
+	lines := make([]string, len(versionProbes))
+	var wg sync.WaitGroup
+	for i, probe := range versionProbes {
+		path, err := exec.LookPath(probe.name)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, path string, args []string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			var out bytes.Buffer
+			cmd := exec.CommandContext(pctx, path, args...)
+			cmd.Stdout = &out
+			cmd.Stderr = &out // java prints its version to stderr
+			if err := cmd.Run(); err != nil && out.Len() == 0 {
+				lines[i] = fmt.Sprintf("%s: error: %v", versionProbes[i].name, err)
+				return
+			}
+			first, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+			lines[i] = fmt.Sprintf("%s: %s", versionProbes[i].name, strings.TrimSpace(first))
+		}(i, path, probe.args)
+	}
+	wg.Wait()
+	var b strings.Builder
+	for _, l := range lines {
+		if l != "" {
+			b.WriteString(l + "\n")
+		}
+	}
+	if b.Len() == 0 {
+		return "none of the known tools were found on PATH"
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	waitPollInterval = 250 * time.Millisecond
+	// waitStablePolls is how many polls in a row a file's size must stay the
+	// same before it counts as completely written.
+	waitStablePolls = 4
+	maxWaitTimeout  = 300 // seconds
+)
+
+// waitForFile polls until path exists inside root, and with stable until its
+// size has stopped changing, or until timeout. Running out of time is not an
+// error: the result reports met=false.
+func waitForFile(ctx context.Context, root, path string, timeout time.Duration, stable bool) (string, error) {
+	abs, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	lastSize, same := int64(-1), 0
+	for {
+		if info, err := os.Stat(abs); err == nil {
+			if info.Size() == lastSize {
+				same++
+			} else {
+				lastSize, same = info.Size(), 0
+			}
+			if !stable || same >= waitStablePolls {
+				return fmt.Sprintf("met=true waited=%s size=%d", time.Since(start).Round(time.Millisecond), info.Size()), nil
+			}
+		} else {
+			lastSize, same = -1, 0
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline.C:
+			return fmt.Sprintf("met=false waited=%s", time.Since(start).Round(time.Millisecond)), nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,7 +1,10 @@
 module agent
 
-go 1.24.0
+go 1.24.1
 
 toolchain go1.24.7
 
-require golang.org/x/net v0.44.0
+require (
+	github.com/ledongthuc/pdf v0.0.0-20260907135840-6c8c28e0e8a0
+	golang.org/x/net v0.44.0
+)